@@ -0,0 +1,167 @@
+// Package clock abstracts the time functions used by the followers' polling
+// loops so they can be driven deterministically in tests.
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock provides the current time, tickers and timers.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker is the subset of time.Ticker used by the followers.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// returns a Clock backed by the time package
+func New() Clock {
+	return realClock{}
+}
+
+// waits for d on c, returning early with ctx.Err() if ctx is done first.
+// Returns immediately for d <= 0.
+func Sleep(ctx context.Context, c Clock, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-c.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// Fake is a manually advanced Clock for tests. Tickers and timers fire
+// only when Advance moves the clock past their deadline.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	// broadcast when a ticker or timer is added, for BlockUntil
+	added *sync.Cond
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	period   time.Duration // zero for one-shot timers
+	c        chan time.Time
+	stopped  bool
+}
+
+// returns a Fake clock set to t
+func NewFake(t time.Time) *Fake {
+	f := &Fake{now: t}
+	f.added = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &fakeWaiter{deadline: f.now.Add(d), c: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	f.added.Broadcast()
+	return w.c
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &fakeWaiter{deadline: f.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	f.added.Broadcast()
+	return &fakeTicker{f: f, w: w}
+}
+
+// moves the clock forward by d, firing any tickers and timers that fall
+// due. Like time.Ticker, ticks are dropped if the receiver is not ready.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	remaining := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.stopped {
+			continue
+		}
+		for !w.deadline.After(f.now) {
+			select {
+			case w.c <- w.deadline:
+			default:
+			}
+			if w.period == 0 {
+				w.stopped = true
+				break
+			}
+			w.deadline = w.deadline.Add(w.period)
+		}
+		if !w.stopped {
+			remaining = append(remaining, w)
+		}
+	}
+	f.waiters = remaining
+}
+
+// blocks until at least n tickers and timers are waiting on the clock, so
+// a test can be sure a goroutine is parked on it before calling Advance.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for {
+		waiting := 0
+		for _, w := range f.waiters {
+			if !w.stopped {
+				waiting++
+			}
+		}
+		if waiting >= n {
+			return
+		}
+		f.added.Wait()
+	}
+}
+
+type fakeTicker struct {
+	f *Fake
+	w *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.c }
+
+func (t *fakeTicker) Stop() {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	t.w.stopped = true
+}
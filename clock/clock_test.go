@@ -0,0 +1,76 @@
+package clock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFakeTicker(t *testing.T) {
+	start := time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	ticker := f.NewTicker(2 * time.Second)
+	after := f.After(3 * time.Second)
+
+	f.Advance(time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker fired early")
+	default:
+	}
+
+	f.Advance(time.Second)
+	select {
+	case got := <-ticker.C():
+		if want := start.Add(2 * time.Second); !got.Equal(want) {
+			t.Errorf("tick at %v, want %v", got, want)
+		}
+	default:
+		t.Fatal("ticker did not fire")
+	}
+
+	f.Advance(time.Second)
+	select {
+	case <-after:
+	default:
+		t.Fatal("timer did not fire")
+	}
+
+	ticker.Stop()
+	f.Advance(10 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Error("stopped ticker fired")
+	default:
+	}
+	if got := f.Now(); !got.Equal(start.Add(13 * time.Second)) {
+		t.Errorf("now is %v", got)
+	}
+}
+
+func TestBlockUntil(t *testing.T) {
+	f := NewFake(time.Now())
+	fired := make(chan struct{})
+	go func() {
+		<-f.After(time.Minute)
+		close(fired)
+	}()
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	<-fired
+}
+
+func TestSleep(t *testing.T) {
+	f := NewFake(time.Now())
+	if err := Sleep(t.Context(), f, 0); err != nil {
+		t.Errorf("zero sleep: %v", err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() { done <- Sleep(ctx, f, time.Hour) }()
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+// follow streams changes from the couch _changes feed or the rss feed and
+// prints them, e.g.
+//
+//	go run ./cmd/follow -source rss -limit 100
+//	go run ./cmd/follow -source couch -since 89797387
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/kmsec-uk/npm-follower/couch"
+	"github.com/kmsec-uk/npm-follower/rss"
+)
+
+func main() {
+	source := flag.String("source", "couch", "feed to follow: couch or rss")
+	interval := flag.Duration("interval", 0, "polling interval (default 2s for couch, 62s for rss)")
+	since := flag.String("since", "", "where to start: a sequence for couch, an RFC3339 time for rss")
+	userAgent := flag.String("user-agent", "", "user-agent sent to the registry")
+	limit := flag.Int("limit", 0, "maximum changes per poll (default unlimited for couch, 50 for rss)")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch *source {
+	case "couch":
+		followCouch(ctx, *interval, *since, *userAgent, *limit)
+	case "rss":
+		followRSS(ctx, *interval, *since, *userAgent, *limit)
+	default:
+		log.Fatalf("unknown source %q, want couch or rss", *source)
+	}
+}
+
+func followCouch(ctx context.Context, interval time.Duration, since, userAgent string, limit int) {
+	f := couch.NewFollower()
+	if interval > 0 {
+		f.WithPollingInterval(interval)
+	}
+	if since != "" {
+		seq, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			log.Fatalf("parsing -since as a sequence: %v", err)
+		}
+		f.Since(seq)
+	}
+	if limit > 0 {
+		f.WithLimit(limit)
+	}
+	if userAgent != "" {
+		f.WithUserAgent(userAgent)
+	}
+	for result := range f.Connect(ctx) {
+		if err := result.Error; err != nil {
+			log.Printf("error polling: %v\n", err)
+			continue
+		}
+		if result.Change.Deleted {
+			log.Printf("%d %s: deleted\n", result.Change.Seq, result.Change.ID)
+			continue
+		}
+		log.Printf("%d %s: updated\n", result.Change.Seq, result.Change.ID)
+	}
+}
+
+func followRSS(ctx context.Context, interval time.Duration, since, userAgent string, limit int) {
+	// see cmd/rss for why the interval is just over the feed's 60s TTL
+	f := rss.NewFollower().WithPollingInterval(62 * time.Second)
+	if interval > 0 {
+		f.WithPollingInterval(interval)
+	}
+	if since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			log.Fatalf("parsing -since as an RFC3339 time: %v", err)
+		}
+		f.WithSince(t)
+	}
+	if limit > 0 {
+		f.WithLimit(limit)
+	}
+	if userAgent != "" {
+		f.WithUserAgent(userAgent)
+	}
+	for result := range f.Connect(ctx) {
+		if err := result.Error; err != nil {
+			log.Println(err)
+			continue
+		}
+		log.Println(result.FeedItem.String())
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/kmsec-uk/npm-follower/clock"
 	"github.com/kmsec-uk/npm-follower/couch"
 )
 
@@ -35,11 +36,8 @@ func main() {
 		}
 		// start goroutine
 		wg.Go(func() {
-			select {
-
-			case <-time.After(10 * time.Second):
-				// wait 10 seconds before getting packument
-			case <-ctx.Done():
+			// wait 10 seconds before getting packument
+			if clock.Sleep(ctx, clock.New(), 10*time.Second) != nil {
 				return
 			}
 			// get full packument details
@@ -14,7 +14,9 @@ func main() {
 	// good way to ensure we poll the next change correctly.
 	// I recommend experimenting pushing the limit parameter higher to
 	// find a balance between context timeout, feed generation error,
-	// and receiving enough events
+	// and receiving enough events. f.LastPollStats() reports whether
+	// the previous poll's last item was found, i.e. whether the limit is
+	// high enough
 	f := rss.NewFollower().WithPollingInterval(62 * time.Second).WithLimit(100)
 	// set a reasonable timeout since RSS is slow to generate
 	f.WithHTTPTimeout(5 * time.Second)
@@ -0,0 +1,63 @@
+package couch
+
+import (
+	"time"
+)
+
+// current version of the ArchiveRecord schema
+const ArchiveSchemaVersion int = 1
+
+// ArchiveRecord is a stable, versioned on-disk representation of a change.
+// Fields are encoded in declaration order:
+//
+//	{"v":1,"package":"pino","seq":89797387,"revs":["37-492f..."],"deleted":false,"observed_at":"2025-12-15T15:42:43Z"}
+type ArchiveRecord struct {
+	Version    int       `json:"v"`
+	Package    string    `json:"package"`
+	Sequence   int       `json:"seq"`
+	Revisions  []string  `json:"revs"`
+	Deleted    bool      `json:"deleted"`
+	ObservedAt time.Time `json:"observed_at"`
+}
+
+// creates an ArchiveRecord for a change observed at the given time
+func NewArchiveRecord(c CouchDocumentChange, observedAt time.Time) ArchiveRecord {
+	revs := make([]string, 0, len(c.Changes))
+	for _, change := range c.Changes {
+		revs = append(revs, change.Rev)
+	}
+	return ArchiveRecord{
+		Version:    ArchiveSchemaVersion,
+		Package:    c.ID,
+		Sequence:   c.Seq,
+		Revisions:  revs,
+		Deleted:    c.Deleted,
+		ObservedAt: observedAt.UTC(),
+	}
+}
+
+// converts the record back into a CouchDocumentChange
+func (a ArchiveRecord) Change() CouchDocumentChange {
+	c := CouchDocumentChange{
+		Seq:     a.Sequence,
+		ID:      a.Package,
+		Deleted: a.Deleted,
+		Changes: make([]CouchRevision, 0, len(a.Revisions)),
+	}
+	for _, rev := range a.Revisions {
+		c.Changes = append(c.Changes, CouchRevision{Rev: rev})
+	}
+	return c
+}
+
+// writes an ArchiveRecord as a single line
+func (w *JSONLinesWriter) EncodeArchive(a ArchiveRecord) error {
+	return w.enc.Encode(a)
+}
+
+// reads the next ArchiveRecord. Returns io.EOF when there are no more lines.
+func (r *JSONLinesReader) DecodeArchive() (ArchiveRecord, error) {
+	var a ArchiveRecord
+	err := r.dec.Decode(&a)
+	return a, err
+}
@@ -0,0 +1,93 @@
+package couch
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kmsec-uk/npm-follower/registry"
+)
+
+// the kind of change a CouchDocumentChange represents, see ClassifyChange.
+type ChangeKind int
+
+const (
+	// a change that doesn't fit any other kind, e.g. a readme or metadata edit
+	ChangeOther ChangeKind = iota
+	// the first snapshot of a package
+	ChangeNewPackage
+	// one or more versions were published
+	ChangeNewVersion
+	// a dist-tag moved without a new version being published
+	ChangeDistTag
+	// the document was deleted and no tombstone remains
+	ChangeDeleted
+	// the package was unpublished, leaving a time.unpublished tombstone
+	ChangeUnpublished
+	// the package was replaced by an npm security holding package
+	ChangeSecurityHolding
+	// there was no previous snapshot to compare with and the package
+	// doesn't look newly created, so what changed can't be told
+	ChangeUnknown
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeOther:
+		return "other"
+	case ChangeNewPackage:
+		return "new package"
+	case ChangeNewVersion:
+		return "new version"
+	case ChangeDistTag:
+		return "dist-tag change"
+	case ChangeDeleted:
+		return "deleted"
+	case ChangeUnpublished:
+		return "unpublished"
+	case ChangeSecurityHolding:
+		return "security holding"
+	case ChangeUnknown:
+		return "unknown"
+	}
+	return "unknown"
+}
+
+// fetches the current packument for a change and classifies it against
+// prev, the last snapshot of the package the caller holds. Pass a nil prev
+// for packages that haven't been seen before: the change is then
+// ChangeNewPackage if the packument looks newly created, by the same test
+// as ConnectNewPackagesOnly, and ChangeUnknown otherwise. Subject to the
+// same update lag as GetPackument, see the README.
+func (f *Follower) ClassifyChange(ctx context.Context, change *CouchDocumentChange, prev *registry.Packument) (ChangeKind, error) {
+	cur, err := f.GetPackument(ctx, change.ID)
+	if errors.Is(err, registry.ErrPackageNotFound) && change.Deleted {
+		return ChangeDeleted, nil
+	}
+	if err != nil {
+		return ChangeOther, err
+	}
+	return classify(change, prev, cur), nil
+}
+
+func classify(change *CouchDocumentChange, prev, cur *registry.Packument) ChangeKind {
+	switch {
+	case cur.IsHoldingPackage():
+		return ChangeSecurityHolding
+	case cur.Time.Unpublished.Time != "":
+		return ChangeUnpublished
+	case change.Deleted:
+		return ChangeDeleted
+	case prev == nil && isNewPackage(cur):
+		return ChangeNewPackage
+	case prev == nil:
+		return ChangeUnknown
+	}
+	diff := registry.DiffPackuments(prev, cur)
+	switch {
+	case len(diff.AddedVersions) > 0:
+		return ChangeNewVersion
+	case len(diff.DistTagChanges) > 0:
+		return ChangeDistTag
+	}
+	return ChangeOther
+}
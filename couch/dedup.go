@@ -0,0 +1,50 @@
+package couch
+
+import (
+	"container/list"
+	"sync"
+)
+
+// dedup is a bounded LRU of recently seen `id@rev` keys.
+type dedup struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	seen  map[string]*list.Element
+}
+
+func newDedup(size int) *dedup {
+	return &dedup{
+		size:  size,
+		order: list.New(),
+		seen:  make(map[string]*list.Element, size),
+	}
+}
+
+// key used to identify a change. Changes without a revision fall back to
+// the document id alone.
+func dedupKey(c CouchDocumentChange) string {
+	if len(c.Changes) == 0 {
+		return c.ID
+	}
+	return c.ID + "@" + c.Changes[0].Rev
+}
+
+// returns true if the change has been seen within the window. The change
+// is recorded as seen either way.
+func (d *dedup) Seen(c CouchDocumentChange) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := dedupKey(c)
+	if el, ok := d.seen[key]; ok {
+		d.order.MoveToFront(el)
+		return true
+	}
+	d.seen[key] = d.order.PushFront(key)
+	if d.order.Len() > d.size {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.seen, oldest.Value.(string))
+	}
+	return false
+}
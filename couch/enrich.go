@@ -0,0 +1,188 @@
+package couch
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kmsec-uk/npm-follower/clock"
+	"github.com/kmsec-uk/npm-follower/registry"
+)
+
+// EnrichedResult is a Result with the packument fetched for the change.
+// Packument is nil for deletions and errors.
+type EnrichedResult struct {
+	Change    CouchDocumentChange
+	Packument *registry.Packument
+	Error     error
+}
+
+// set the number of concurrent packument fetches made by
+// ConnectWithPackuments. Default is 1, which preserves feed order.
+func (f *Follower) WithEnrichConcurrency(n int) *Follower {
+	f.enrichConcurrency = n
+	return f
+}
+
+// wait until d has passed since a change was observed before fetching its
+// packument, giving the registry CDN time to catch up with _changes and
+// avoiding stale _rev mismatches. Changes are timestamped as they arrive,
+// so the delay overlaps across changes rather than accumulating.
+func (f *Follower) WithEnrichDelay(d time.Duration) *Follower {
+	f.enrichDelay = d
+	return f
+}
+
+// bound the packument fetch for each change to d, so one hung fetch is
+// abandoned without stalling the rest of the pool. A timed-out change is
+// delivered with the fetch error. Default is 0, no per-change timeout;
+// requests are still bounded by the client's HTTP timeout.
+func (f *Follower) WithEnrichTimeout(d time.Duration) *Follower {
+	f.enrichTimeout = d
+	return f
+}
+
+// only deliver enriched changes whose most recently published version
+// passes keep. For example, to drop prerelease publishes:
+//
+//	f.WithVersionFilter(func(v string) bool { return !registry.IsPrerelease(v) })
+//
+// Deletions and errors are always delivered.
+func (f *Follower) WithVersionFilter(keep func(version string) bool) *Follower {
+	f.versionFilter = keep
+	return f
+}
+
+// connect and start issuing EnrichedResults to channel. Each non-deleted
+// change has its packument fetched before delivery.
+func (f *Follower) ConnectWithPackuments(ctx context.Context) <-chan EnrichedResult {
+	results := f.Connect(ctx)
+	out := make(chan EnrichedResult, f.loop.BufferSize)
+
+	// timestamp changes as they arrive, for WithEnrichDelay
+	type observed struct {
+		result Result
+		at     time.Time
+	}
+	in := make(chan observed, f.loop.BufferSize)
+	go func() {
+		defer close(in)
+		for result := range results {
+			select {
+			case in <- observed{result, f.loop.Clock.Now()}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(out)
+		var wg sync.WaitGroup
+		for range max(f.enrichConcurrency, 1) {
+			wg.Go(func() {
+				for o := range in {
+					if o.result.Error == nil {
+						wait := o.at.Add(f.enrichDelay).Sub(f.loop.Clock.Now())
+						if clock.Sleep(ctx, f.loop.Clock, wait) != nil {
+							return
+						}
+					}
+					enriched, ok := f.enrich(ctx, o.result)
+					if !ok {
+						continue
+					}
+					select {
+					case out <- enriched:
+					case <-ctx.Done():
+						return
+					}
+				}
+			})
+		}
+		wg.Wait()
+	}()
+	return out
+}
+
+// fetches the packument for a Result. Returns false if the change should
+// be dropped by the version filter.
+func (f *Follower) enrich(ctx context.Context, result Result) (EnrichedResult, bool) {
+	enriched := EnrichedResult{Change: result.Change, Error: result.Error}
+	if result.Error != nil || result.Change.Deleted {
+		return enriched, true
+	}
+	if f.enrichTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.enrichTimeout)
+		defer cancel()
+	}
+	p, err := f.GetPackument(ctx, result.Change.ID)
+	// a cached packument may predate this change
+	if err == nil && !result.Change.HasRevision(p.Rev) && f.EvictPackument(result.Change.ID) {
+		p, err = f.GetPackument(ctx, result.Change.ID)
+	}
+	if err != nil {
+		enriched.Error = err
+		return enriched, true
+	}
+	enriched.Packument = p
+	if f.versionFilter != nil {
+		if v := p.MostRecentVersion(); v != nil && !f.versionFilter(v.Version) {
+			return enriched, false
+		}
+	}
+	return enriched, true
+}
+
+// how close time.created and time.modified must be for a packument to be
+// considered new by ConnectNewPackagesOnly
+const newPackageWindow = time.Minute
+
+// connect and issue EnrichedResults only for packages that look newly
+// created, e.g. for squatting or typosquatting detection. A package is new
+// if it has exactly one version, or if its time.created is within a minute
+// of time.modified. Security holding packages and deletions are dropped;
+// errors are always delivered.
+//
+// This is a heuristic: a later metadata-only change (readme, dist-tag) to a
+// package that still has a single version is reported again, as is a
+// package whose other versions have all been unpublished, so deduplicate by
+// name if that matters. Packages created and quickly given further versions
+// before the packument is fetched may be missed.
+func (f *Follower) ConnectNewPackagesOnly(ctx context.Context) <-chan EnrichedResult {
+	in := f.ConnectWithPackuments(ctx)
+	out := make(chan EnrichedResult, f.loop.BufferSize)
+	go func() {
+		defer close(out)
+		for r := range in {
+			if r.Error == nil && (r.Packument == nil || !isNewPackage(r.Packument)) {
+				continue
+			}
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func isNewPackage(p *registry.Packument) bool {
+	if p.IsHoldingPackage() {
+		return false
+	}
+	if len(p.Versions) == 1 {
+		return true
+	}
+	created, err := time.Parse(time.RFC3339, p.Time.Created)
+	if err != nil {
+		return false
+	}
+	modified, err := time.Parse(time.RFC3339, p.Time.Modified)
+	if err != nil {
+		return false
+	}
+	return modified.Sub(created) < newPackageWindow
+}
@@ -0,0 +1,51 @@
+package couch
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kmsec-uk/npm-follower/event"
+)
+
+// connect and start issuing source-agnostic event.Events to channel.
+func (f *Follower) ConnectEvents(ctx context.Context) <-chan event.Event {
+	in := f.Connect(ctx)
+	out := make(chan event.Event, f.loop.BufferSize)
+	go func() {
+		defer close(out)
+		for result := range in {
+			e := event.Event{
+				Source:      event.SourceCouch,
+				Registry:    f.databaseURL(),
+				PackageName: result.Change.ID,
+				Sequence:    uint64(result.Change.Seq),
+				Timestamp:   result.ObservedAt,
+				Error:       result.Error,
+			}
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// returns the sequence of the newest change handed to the Connect channel
+// as a ResumeToken. It only moves once a change has been sent, so resuming
+// from it never skips a change the follower fetched but didn't deliver.
+// Changes still waiting in the channel buffer count as delivered; use
+// WithBufferSize(0) if those must not be lost when the process exits.
+func (f *Follower) Token() event.ResumeToken {
+	return event.ResumeToken{Source: event.SourceCouch, Sequence: f.delivered.Load()}
+}
+
+// resume from a token previously returned by Token. Call before Connect.
+func (f *Follower) ResumeFrom(t event.ResumeToken) error {
+	if t.Source != event.SourceCouch {
+		return fmt.Errorf("resuming couch follower: %w", event.ErrTokenSource)
+	}
+	f.Since(t.Sequence)
+	return nil
+}
@@ -0,0 +1,48 @@
+package couch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// DBInfo is the database information returned by the replicate root.
+type DBInfo struct {
+	DBName            string `json:"db_name"`
+	DocCount          uint64 `json:"doc_count"`
+	DocDelCount       uint64 `json:"doc_del_count"`
+	UpdateSequence    uint64 `json:"update_seq"`
+	CompactRunning    bool   `json:"compact_running"`
+	InstanceStartTime string `json:"instance_start_time"`
+}
+
+// fetches the replicate database info (doc count, update sequence etc).
+// Useful as a readiness check before following.
+func (f *Follower) Info(ctx context.Context) (*DBInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", f.databaseURL(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%screating request: %w", requestPrefix(ctx), err)
+	}
+	req.Header.Add(
+		"user-agent", f.UserAgent,
+	)
+	if id := RequestID(ctx); id != "" {
+		req.Header.Set("x-request-id", id)
+	}
+	res, err := f.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%sdoing request: %w", requestPrefix(ctx), err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%sunexpected status %v from %s", requestPrefix(ctx), res.StatusCode, res.Request.URL)
+	}
+	var info DBInfo
+	r, debug := f.DebugReader(f.LimitBody(res.Body))
+	err = debug(json.NewDecoder(r).Decode(&info))
+	if err != nil {
+		return nil, fmt.Errorf("%sdecoding body: %w", requestPrefix(ctx), err)
+	}
+	return &info, nil
+}
@@ -0,0 +1,65 @@
+package couch
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// a single NDJSON record: the change fields inlined, plus the time the
+// Result was observed and the error message for error Results.
+type jsonLine struct {
+	CouchDocumentChange
+	Timestamp time.Time `json:"timestamp"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// JSONLinesWriter writes Results as newline-delimited JSON, one compact
+// object per line, for piping into jq, Kafka producers etc.
+type JSONLinesWriter struct {
+	enc *json.Encoder
+}
+
+func NewJSONLinesWriter(w io.Writer) *JSONLinesWriter {
+	return &JSONLinesWriter{enc: json.NewEncoder(w)}
+}
+
+// writes the Result as a single line, timestamped with its ObservedAt, or
+// the current time if that is unset.
+func (w *JSONLinesWriter) Encode(r Result) error {
+	ts := r.ObservedAt
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	line := jsonLine{CouchDocumentChange: r.Change, Timestamp: ts.UTC()}
+	if r.Error != nil {
+		line.Error = r.Error.Error()
+	}
+	return w.enc.Encode(line)
+}
+
+// JSONLinesReader reads Results written by a JSONLinesWriter, e.g. to
+// replay a recorded stream.
+type JSONLinesReader struct {
+	dec *json.Decoder
+}
+
+func NewJSONLinesReader(r io.Reader) *JSONLinesReader {
+	return &JSONLinesReader{dec: json.NewDecoder(bufio.NewReader(r))}
+}
+
+// reads the next Result and its timestamp, which is also set as the
+// Result's ObservedAt. Returns io.EOF when there are no more lines.
+func (r *JSONLinesReader) Decode() (Result, time.Time, error) {
+	var line jsonLine
+	if err := r.dec.Decode(&line); err != nil {
+		return Result{}, time.Time{}, err
+	}
+	result := Result{Change: line.CouchDocumentChange, ObservedAt: line.Timestamp}
+	if line.Error != "" {
+		result.Error = errors.New(line.Error)
+	}
+	return result, line.Timestamp, nil
+}
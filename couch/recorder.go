@@ -0,0 +1,81 @@
+package couch
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// default interval between flushes of a Recorder's buffered output
+const defaultFlushInterval time.Duration = time.Second
+
+// Recorder tees a Result stream to NDJSON (see JSONLinesWriter) while
+// forwarding every Result downstream, e.g. to capture production traffic
+// as test fixtures and later replay it with a JSONLinesReader.
+type Recorder struct {
+	w             *bufio.Writer
+	enc           *JSONLinesWriter
+	out           chan Result
+	flushInterval time.Duration
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	bw := bufio.NewWriter(w)
+	return &Recorder{
+		w:             bw,
+		enc:           NewJSONLinesWriter(bw),
+		out:           make(chan Result),
+		flushInterval: defaultFlushInterval,
+	}
+}
+
+// flush buffered records to the underlying writer every d. Default is 1
+// second. Output is always flushed when Consume returns.
+func (r *Recorder) WithFlushInterval(d time.Duration) *Recorder {
+	r.flushInterval = d
+	return r
+}
+
+// returns the channel Results are forwarded on. It must be received from
+// while Consume runs, and is closed when Consume returns.
+func (r *Recorder) Results() <-chan Result {
+	return r.out
+}
+
+// records and forwards Results until the channel is closed or ctx is done,
+// flushing periodically and before returning. Returns the first write
+// error, or the context's cause if it was cancelled.
+func (r *Recorder) Consume(ctx context.Context, results <-chan Result) (err error) {
+	defer close(r.out)
+	defer func() {
+		if ferr := r.w.Flush(); ferr != nil && err == nil {
+			err = fmt.Errorf("recorder: flushing: %w", ferr)
+		}
+	}()
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				return nil
+			}
+			if err := r.enc.Encode(result); err != nil {
+				return fmt.Errorf("recorder: %s: encoding: %w", result.Change.ID, err)
+			}
+			select {
+			case r.out <- result:
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			}
+		case <-ticker.C:
+			if err := r.w.Flush(); err != nil {
+				return fmt.Errorf("recorder: flushing: %w", err)
+			}
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
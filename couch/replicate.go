@@ -1,16 +1,23 @@
 package couch
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/kmsec-uk/npm-follower/clock"
+	"github.com/kmsec-uk/npm-follower/internal/poll"
 	"github.com/kmsec-uk/npm-follower/registry"
 )
 
@@ -21,8 +28,12 @@ type CouchDocumentChange struct {
 	Deleted bool            `json:"deleted,omitempty"`
 }
 
-// check if a specific revision is present in the list of changes from CouchDB
+// check if a specific revision is present in the list of changes from CouchDB.
+// An empty rev (e.g. from a malformed packument) never matches.
 func (c CouchDocumentChange) HasRevision(rev string) bool {
+	if rev == "" {
+		return false
+	}
 	for _, change := range c.Changes {
 		if change.Rev == rev {
 			return true
@@ -32,6 +43,32 @@ func (c CouchDocumentChange) HasRevision(rev string) bool {
 
 }
 
+// returns the revisions of a deleted document. Returns nil if the change
+// is not a deletion.
+func (c CouchDocumentChange) DeletedRevs() []string {
+	if !c.Deleted {
+		return nil
+	}
+	revs := make([]string, 0, len(c.Changes))
+	for _, change := range c.Changes {
+		revs = append(revs, change.Rev)
+	}
+	return revs
+}
+
+// returns the scope of the changed package without the leading @, e.g.
+// "types" for @types/node. Empty for unscoped packages.
+func (c CouchDocumentChange) Scope() string {
+	scope, _ := registry.SplitPackageName(c.ID)
+	return scope
+}
+
+// returns the package name without its scope, e.g. "node" for @types/node.
+func (c CouchDocumentChange) BareName() string {
+	_, bare := registry.SplitPackageName(c.ID)
+	return bare
+}
+
 type CouchRevision struct {
 	Rev string `json:"rev"`
 }
@@ -39,12 +76,16 @@ type CouchRevision struct {
 type CouchResponse struct {
 	Results      []CouchDocumentChange `json:"results"`
 	LastSequence uint64                `json:"last_seq"`
+	Pending      uint64                `json:"pending"`
 }
 
 // Result is what the Follower returns while connected
 type Result struct {
 	Change CouchDocumentChange
 	Error  error
+	// when the follower delivered the Result, for measuring propagation
+	// latency against the packument's publish time
+	ObservedAt time.Time
 }
 
 type Follower struct {
@@ -52,23 +93,101 @@ type Follower struct {
 	// Client          *http.Client
 	// userAgent       string
 
-	Sequence        atomic.Uint64
+	Sequence       atomic.Uint64
+	delivered      atomic.Uint64
+	pending        atomic.Uint64
+	loop           poll.Loop[Result]
+	statusMu       sync.Mutex
+	requestTimeout time.Duration
+	dedup          *dedup
+	filter         string
+	filterParams   map[string]string
+	selector       map[string]any
+	requestID      func() string
+	replicateURL   string
+	database       string
+
+	limit        int
+	gapDetection bool
+	descending   bool
+	floor        uint64
+	failFast     bool
+
+	// cold start
+	sinceApprox      time.Duration
+	changesPerSecond float64
+	fromBeginning    bool
+
+	enrichConcurrency int
+	versionFilter     func(version string) bool
+	enrichDelay       time.Duration
+	enrichTimeout     time.Duration
+
+	onReconnect func(lastSeq uint64)
+
+	// requested polling interval, floored at minInterval by Connect unless
+	// unsafeInterval is set
 	pollingInterval time.Duration
+	minInterval     time.Duration
+	unsafeInterval  bool
+
+	backoffInitial time.Duration
+	backoffMax     time.Duration
+
+	// changes delivered per poll within changeRateWindow, guarded by statusMu
+	deliveries []delivery
+}
+
+type delivery struct {
+	at    time.Time
+	count int
 }
 
 var ErrInvalidUpdateSequence error = errors.New("invalid update sequence")
 
+// delivered as a Result error by WithGapDetection when consecutive
+// changes are not contiguous in sequence
+var ErrSequenceGap error = errors.New("sequence gap")
+
+// returned when Connect is called on a Follower that is already connected
+var ErrAlreadyConnected error = poll.ErrAlreadyConnected
+
+// returned alongside the latest fetched packument when its _rev never
+// matched the change's revisions
+var ErrRevisionMismatch error = errors.New("packument revision does not match change")
+
 const (
-	replicateRegistry string = "https://replicate.npmjs.com/registry/"
+	defaultReplicateURL string = "https://replicate.npmjs.com"
+	defaultDatabase     string = "registry"
+	// upper bound on the per-fetch timeout
+	maxRequestTimeout time.Duration = 10 * time.Second
+	// rough rate of changes on the public registry, used by SinceApprox
+	defaultChangesPerSecond float64 = 5
+	// floor applied by WithPollingInterval
+	defaultMinInterval time.Duration = time.Second
+	// window ChangeRate is averaged over
+	changeRateWindow time.Duration = time.Minute
 )
 
 // creates a new Follower instance
 // by default, the follower excludes deletion events
 func NewFollower() *Follower {
-	return &Follower{
-		RegistryClient:  registry.NewClient(),
-		pollingInterval: 2 * time.Second,
+	f := &Follower{
+		RegistryClient:   registry.NewClient(),
+		pollingInterval:  2 * time.Second,
+		minInterval:      defaultMinInterval,
+		changesPerSecond: defaultChangesPerSecond,
+		replicateURL:     defaultReplicateURL,
+		database:         defaultDatabase,
 	}
+	f.loop.BufferSize = 10
+	f.loop.Clock = clock.New()
+	f.loop.ErrorResult = func(err error) Result { return Result{Error: err} }
+	f.loop.Stamp = func(r Result, at time.Time) Result {
+		r.ObservedAt = at
+		return r
+	}
+	return f
 }
 
 // // use a custom user agent
@@ -84,9 +203,243 @@ func (f *Follower) WithHTTPTimeout(t time.Duration) *Follower {
 }
 
 // set the polling interval for the follower. Default is 2 seconds
-// which is more than frequent enough to capture all events
+// which is more than frequent enough to capture all events. Connect raises
+// intervals below the minimum (see WithMinInterval) to it with a warning,
+// and t <= 0 is ignored.
 func (f *Follower) WithPollingInterval(t time.Duration) *Follower {
+	if t <= 0 {
+		return f
+	}
 	f.pollingInterval = t
+	f.unsafeInterval = false
+	return f
+}
+
+// set the polling interval without applying the minimum interval floor,
+// for private CouchDB replicas that can take the load. t <= 0 is ignored.
+func (f *Follower) WithPollingIntervalUnsafe(t time.Duration) *Follower {
+	if t <= 0 {
+		return f
+	}
+	f.pollingInterval = t
+	f.unsafeInterval = true
+	return f
+}
+
+// set the floor applied to WithPollingInterval, which protects
+// replicate.npmjs.com from aggressive polling. Default is 1 second. The
+// floor is applied when Connect is called, so the order of the calls
+// doesn't matter.
+func (f *Follower) WithMinInterval(t time.Duration) *Follower {
+	f.minInterval = t
+	return f
+}
+
+// the interval Connect polls at, the requested one with the WithMinInterval
+// floor applied unless it was set by WithPollingIntervalUnsafe
+func (f *Follower) interval() time.Duration {
+	if f.unsafeInterval {
+		return f.pollingInterval
+	}
+	return max(f.pollingInterval, f.minInterval)
+}
+
+// schedule each _changes poll one polling interval after the previous one
+// started, less the time the fetch took, instead of on a fixed ticker. This
+// keeps the cadence steady when fetches are slow, e.g. catching up with a
+// large WithLimit; a fetch longer than the interval is followed immediately
+// by the next. Without it, ticks that fall due during a slow fetch are
+// skipped, see SkippedTicks.
+func (f *Follower) WithDriftCorrection() *Follower {
+	f.loop.DriftCorrection = true
+	return f
+}
+
+// set the per-fetch context timeout used when polling _changes. By default
+// this is derived from the polling interval, see requestTimeoutOrDefault.
+func (f *Follower) WithRequestTimeout(t time.Duration) *Follower {
+	f.requestTimeout = t
+	return f
+}
+
+// returns the explicit request timeout if set, otherwise
+// min(10s, pollingInterval - 10%) so a slow fetch is cancelled before
+// the next tick is due rather than delaying it.
+func (f *Follower) requestTimeoutOrDefault() time.Duration {
+	if f.requestTimeout > 0 {
+		return f.requestTimeout
+	}
+	interval := f.interval()
+	return min(maxRequestTimeout, interval-interval/10)
+}
+
+// delay the first _changes poll by a random duration up to maxDelay, so a
+// fleet of followers restarted together doesn't hit the replicate server in
+// lockstep.
+func (f *Follower) WithStartupJitter(maxDelay time.Duration) *Follower {
+	f.loop.StartupJitter = maxDelay
+	return f
+}
+
+// use a custom Clock for the polling loop, e.g. clock.Fake in tests.
+func (f *Follower) WithClock(c clock.Clock) *Follower {
+	f.loop.Clock = c
+	return f
+}
+
+// set the capacity of the Result channel returned by Connect. Default is 10.
+// A larger buffer lets bursts be absorbed without blocking the poller; 0
+// makes the channel unbuffered. Negative sizes are treated as 0.
+func (f *Follower) WithBufferSize(n int) *Follower {
+	f.loop.BufferSize = max(n, 0)
+	return f
+}
+
+// apply a server-side CouchDB filter function to _changes, given as
+// "<ddoc>/<func>". params are passed as additional query parameters to
+// the filter. npm's public replicate may not allow arbitrary filters, but
+// private mirrors do.
+func (f *Follower) WithFilter(name string, params map[string]string) *Follower {
+	f.filter = name
+	f.filterParams = params
+	return f
+}
+
+// filter _changes server-side with a CouchDB Mango selector, e.g.
+//
+//	f.WithSelector(map[string]any{"maintainers": map[string]any{"$elemMatch": map[string]any{"name": "kmsec-uk"}}})
+//
+// The _changes request becomes a POST with filter=_selector and the
+// selector as the body. Takes precedence over WithFilter. npm's public
+// replicate may not support selectors, but private CouchDB mirrors do.
+func (f *Follower) WithSelector(selector map[string]any) *Follower {
+	f.selector = selector
+	return f
+}
+
+// drop changes whose `id@rev` has already been delivered within the last
+// windowSize changes. Disabled by default (raw passthrough).
+func (f *Follower) WithDedup(windowSize int) *Follower {
+	if windowSize <= 0 {
+		f.dedup = nil
+		return f
+	}
+	f.dedup = newDedup(windowSize)
+	return f
+}
+
+// follow a different CouchDB server, e.g. a private replica.
+// Default is https://replicate.npmjs.com
+func (f *Follower) WithReplicateURL(u string) *Follower {
+	f.replicateURL = strings.TrimSuffix(u, "/")
+	return f
+}
+
+// follow a database other than `registry` on the replicate server, for
+// CouchDB deployments that expose the npm data under a different name.
+func (f *Follower) WithDatabase(name string) *Follower {
+	f.database = strings.Trim(name, "/")
+	return f
+}
+
+// returns the database URL with a trailing slash,
+// e.g. https://replicate.npmjs.com/registry/
+func (f *Follower) databaseURL() string {
+	return f.replicateURL + "/" + url.PathEscape(f.database) + "/"
+}
+
+// maximum number of changes requested per poll. Default is 0 (no limit).
+func (f *Follower) WithLimit(n int) *Follower {
+	f.limit = n
+	return f
+}
+
+// deliver a Result with an ErrSequenceGap error whenever a change's
+// sequence does not directly follow the previous one, e.g. after a
+// reconnect skipped some changes. Note this can false-positive: _changes
+// only lists the latest revision of each document, so earlier sequences
+// of a document updated again are omitted, as are any sequences batched
+// away by the server (see CouchDB's seq_interval).
+func (f *Follower) WithGapDetection() *Follower {
+	f.gapDetection = true
+	return f
+}
+
+// drop a change rather than block polling when the consumer hasn't
+// received it within d. The sequence has already advanced past a dropped
+// change, so it is never redelivered: delivery becomes at-most-once. See
+// Dropped. Default is 0, blocking until the consumer is ready.
+func (f *Follower) WithResultTimeout(d time.Duration) *Follower {
+	f.loop.ResultTimeout = d
+	return f
+}
+
+// returns the number of changes dropped by WithResultTimeout.
+func (f *Follower) Dropped() uint64 {
+	return f.loop.Dropped()
+}
+
+// close the channel after delivering the first poll error or sequence gap,
+// for one-shot jobs such as a WithDescending scan that should exit on
+// failure. By default errors are delivered as Results and polling carries on
+// from the last sequence.
+func (f *Follower) WithFailFast() *Follower {
+	f.failFast = true
+	return f
+}
+
+// after a failed poll, wait before polling again: initial after the first
+// failure, doubling with each consecutive failure up to maxDelay. Ticks
+// that fall due while backing off are skipped. Polling resumes at the
+// normal interval, from the last sequence, once a poll succeeds. Disabled
+// by default, so failed polls are retried on the next tick.
+func (f *Follower) WithBackoff(initial, maxDelay time.Duration) *Follower {
+	f.backoffInitial = initial
+	f.backoffMax = maxDelay
+	return f
+}
+
+// returns the backoff after n consecutive failures
+func (f *Follower) backoff(n int) time.Duration {
+	d := f.backoffInitial
+	for i := 1; i < n && d < f.backoffMax; i++ {
+		d *= 2
+	}
+	return min(d, f.backoffMax)
+}
+
+// call fn on the polling goroutine whenever a _changes poll fails or
+// WithGapDetection finds a gap, so errors can be routed to alerting
+// separately from the Result stream. Errors are still delivered as Results
+// unless WithoutErrorResults is set.
+func (f *Follower) WithOnError(fn func(error)) *Follower {
+	f.loop.OnError = fn
+	return f
+}
+
+// stop delivering poll errors and sequence gaps as Results, so every Result
+// carries a change. Pair with WithOnError so errors aren't silently lost.
+func (f *Follower) WithoutErrorResults() *Follower {
+	f.loop.SuppressErrors = true
+	return f
+}
+
+// call fn whenever a poll succeeds after one or more failed polls, with the
+// sequence the follower is resuming from. fn runs on the polling goroutine
+// so should return quickly.
+func (f *Follower) WithOnReconnect(fn func(lastSeq uint64)) *Follower {
+	f.onReconnect = fn
+	return f
+}
+
+// walk _changes in descending order for historical scans, paging from the
+// starting sequence (Since, or the current sequence on cold start) down
+// towards floor. Changes below floor are dropped, and the channel is closed
+// once the floor or the start of the feed is reached. Combine with
+// WithLimit to control the page size.
+func (f *Follower) WithDescending(floor uint64) *Follower {
+	f.descending = true
+	f.floor = floor
 	return f
 }
 
@@ -94,132 +447,311 @@ func (f *Follower) WithPollingInterval(t time.Duration) *Follower {
 // Follower starts from current (most recent) sequence
 func (f *Follower) Since(sequence uint64) *Follower {
 	f.Sequence.Store(sequence)
+	f.delivered.Store(sequence)
+	return f
+}
+
+// start from the very first change (since=0) and replay the full history,
+// rather than cold starting from the current sequence. Since(0) can't be
+// used for this as a zero sequence means cold start. Combine with
+// WithLimit to page through history in batches.
+func (f *Follower) SinceBeginning() *Follower {
+	f.Sequence.Store(0)
+	f.fromBeginning = true
+	return f
+}
+
+// start roughly d in the past rather than from the current sequence.
+// CouchDB sequences are not timestamps, so the starting sequence is
+// approximated on cold start as the current sequence minus d multiplied by
+// an assumed change rate (see WithChangeRate). Treat it as a ballpark only.
+func (f *Follower) SinceApprox(d time.Duration) *Follower {
+	f.sinceApprox = d
 	return f
 }
 
+// set the assumed number of changes per second used by SinceApprox.
+// Default is 5.
+func (f *Follower) WithChangeRate(perSecond float64) *Follower {
+	f.changesPerSecond = perSecond
+	return f
+}
+
+// returns the number of changes remaining after the most recently fetched
+// batch, as reported by CouchDB. Useful for gauging catch-up progress.
+func (f *Follower) Pending() uint64 {
+	return f.pending.Load()
+}
+
+// returns the number of polling ticks skipped because a _changes fetch
+// ran past the polling interval. WithDriftCorrection doesn't use a ticker,
+// so nothing is counted with it.
+func (f *Follower) SkippedTicks() uint64 {
+	return f.loop.SkippedTicks()
+}
+
+// returns the time of the last successful _changes poll, or the zero time
+// if no poll has succeeded yet.
+func (f *Follower) LastPollTime() time.Time {
+	return f.loop.LastPollTime()
+}
+
+// returns the error from the most recent _changes poll, or nil if it
+// succeeded.
+func (f *Follower) LastError() error {
+	return f.loop.LastError()
+}
+
+// returns the number of changes returned by the last successful poll,
+// before dedup and descending-floor filtering.
+func (f *Follower) LastPollCount() int {
+	return f.loop.LastPollCount()
+}
+
+// records count changes delivered by a poll for ChangeRate, pruning
+// deliveries older than the window
+func (f *Follower) recordDeliveries(count int) {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+	now := f.loop.Clock.Now()
+	cutoff := now.Add(-changeRateWindow)
+	i := 0
+	for i < len(f.deliveries) && !f.deliveries[i].at.After(cutoff) {
+		i++
+	}
+	f.deliveries = append(f.deliveries[i:], delivery{at: now, count: count})
+}
+
+// returns the rate of changes delivered, in changes per second, averaged
+// over the last minute. Useful for sizing downstream consumers; a sudden
+// spike often means a mass-publish event. Under-reports for the first
+// minute after connecting.
+func (f *Follower) ChangeRate() float64 {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+	cutoff := f.loop.Clock.Now().Add(-changeRateWindow)
+	total := 0
+	for _, d := range f.deliveries {
+		if d.at.After(cutoff) {
+			total += d.count
+		}
+	}
+	return float64(total) / changeRateWindow.Seconds()
+}
+
+// stops polling and closes the channel returned by Connect. Calling Stop
+// before Connect, or more than once, is a no-op.
+func (f *Follower) Stop() {
+	f.loop.Stop()
+}
+
 // connect and start issuing Results to channel.
 func (f *Follower) Connect(ctx context.Context) <-chan Result {
-
-	out := make(chan Result, 10)
+	f.loop.Interval = f.interval()
+	if f.loop.Interval != f.pollingInterval {
+		log.Printf("polling interval %v is below the minimum of %v, using the minimum\n", f.pollingInterval, f.minInterval)
+	}
 	// if we haven't been given a sequence to start with, do cold start
-	if f.Sequence.Load() == 0 {
-		err := f.coldStartSequence(ctx)
-		if err != nil {
-			go func() {
-				out <- Result{Error: fmt.Errorf("cold start failed: %w", err)}
-				close(out)
-			}()
-			return out
+	start := func(ctx context.Context) error {
+		if f.Sequence.Load() != 0 || f.fromBeginning {
+			return nil
 		}
+		if err := f.coldStartSequence(f.withRequestID(ctx)); err != nil {
+			return fmt.Errorf("cold start failed: %w", err)
+		}
+		f.delivered.Store(f.Sequence.Load())
+		return nil
 	}
+	// consecutive failed polls, for WithOnReconnect and WithBackoff
+	failures := 0
+	var retryAt time.Time
+	return f.loop.Run(ctx, start, func(ctx context.Context, out chan<- Result) bool {
+		if f.loop.Clock.Now().Before(retryAt) {
+			return false
+		}
+		reqCtx, cancel := context.WithTimeout(f.withRequestID(ctx), f.requestTimeoutOrDefault())
+		defer cancel()
 
-	go func() {
-		defer close(out)
-		ticker := time.NewTicker(f.pollingInterval)
-		defer ticker.Stop()
-
-		fetch := func() {
-			// hard-stop 10 second context timeout
-			reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-			defer cancel()
+		// sequence the batch continues from, for gap detection
+		prev := f.Sequence.Load()
+		changes, err := f.getChanges(reqCtx)
+		f.loop.RecordPoll(len(changes), err)
+		if err != nil {
+			failures++
+			if f.backoffInitial > 0 {
+				retryAt = f.loop.Clock.Now().Add(f.backoff(failures))
+			}
+			f.loop.Fail(ctx, out, err)
+			return f.failFast
+		}
+		if failures > 0 {
+			failures = 0
+			if f.onReconnect != nil {
+				f.onReconnect(prev)
+			}
+		}
 
-			changes, err := f.getChanges(reqCtx)
-			if err != nil {
-				select {
-				case out <- Result{Error: err}:
-				case <-ctx.Done():
+		// a descending scan is finished once it reaches the floor
+		finished := f.descending && (len(changes) == 0 || f.Sequence.Load() <= f.floor)
 
+		delivered := 0
+		defer func() { f.recordDeliveries(delivered) }()
+		for _, change := range changes {
+			seq := uint64(change.Seq)
+			// check contiguity before any filtering, so changes that
+			// are dropped below still advance prev
+			if f.gapDetection && !f.descending {
+				if seq != prev+1 {
+					gap := fmt.Errorf("%w: expected %d, got %d", ErrSequenceGap, prev+1, seq)
+					if !f.loop.Fail(ctx, out, gap) {
+						return finished
+					}
+					if f.failFast {
+						return true
+					}
 				}
-				return
+				prev = seq
 			}
-
-			for _, change := range changes {
-				select {
-				case out <- Result{Change: change}:
-				case <-ctx.Done():
-					return
-				}
+			if f.descending && seq < f.floor {
+				continue
 			}
-
-		}
-
-		fetch()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				fetch()
+			if f.dedup != nil && f.dedup.Seen(change) {
+				continue
+			}
+			if !f.loop.Send(ctx, out, Result{Change: change}) {
+				return finished
 			}
+			f.delivered.Store(seq)
+			delivered++
 		}
-	}()
-	return out
+		// the whole batch is out, including changes filtered above
+		f.delivered.Store(f.Sequence.Load())
+		return finished
+	})
 }
 
 // get changes from _changes and return the whole couch result body.
 // the sequence is updated in this func
 func (f *Follower) getChanges(ctx context.Context) ([]CouchDocumentChange, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", replicateRegistry+"_changes", nil)
+	method, body := "GET", io.Reader(nil)
+	if f.selector != nil {
+		b, err := json.Marshal(map[string]any{"selector": f.selector})
+		if err != nil {
+			return nil, fmt.Errorf("%ssequence %v: encoding selector: %w", requestPrefix(ctx), f.Sequence.Load(), err)
+		}
+		method, body = "POST", bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, f.databaseURL()+"_changes", body)
 	if err != nil {
-		return nil, fmt.Errorf("sequence %v: creating request: %w", f.Sequence.Load(), err)
+		return nil, fmt.Errorf("%ssequence %v: creating request: %w", requestPrefix(ctx), f.Sequence.Load(), err)
+	}
+	if body != nil {
+		req.Header.Set("content-type", "application/json")
 	}
 	// user-agent
 	req.Header.Add("user-agent", f.UserAgent)
+	if id := RequestID(ctx); id != "" {
+		req.Header.Set("x-request-id", id)
+	}
 	// sequence
 	q := req.URL.Query()
 	q.Add("since", strconv.FormatUint(f.Sequence.Load(), 10))
+	if f.limit > 0 {
+		q.Set("limit", strconv.Itoa(f.limit))
+	}
+	if f.descending {
+		q.Set("descending", "true")
+	}
+	// server-side filter
+	if f.selector != nil {
+		q.Set("filter", "_selector")
+	} else if f.filter != "" {
+		q.Set("filter", f.filter)
+		for k, v := range f.filterParams {
+			q.Set(k, v)
+		}
+	}
 	req.URL.RawQuery = q.Encode()
 
-	res, err := f.Client.Do(req)
+	res, err := f.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("sequence %v: doing request: %w", f.Sequence.Load(), err)
+		return nil, fmt.Errorf("%ssequence %v: doing request: %w", requestPrefix(ctx), f.Sequence.Load(), err)
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("sequence %v: unexpected status %v from %s", f.Sequence.Load(), res.StatusCode, res.Request.URL)
+		return nil, fmt.Errorf("%ssequence %v: unexpected status %v from %s", requestPrefix(ctx), f.Sequence.Load(), res.StatusCode, res.Request.URL)
 	}
 	var cr CouchResponse
-	err = json.NewDecoder(res.Body).Decode(&cr)
+	r, debug := f.DebugReader(f.LimitBody(res.Body))
+	err = debug(json.NewDecoder(r).Decode(&cr))
 	// fmt.Printf("got %d updates", len(cr.Results))
 	if err != nil {
-		return nil, fmt.Errorf("sequence %v: decoding body: %w", f.Sequence.Load(), err)
+		return nil, fmt.Errorf("%ssequence %v: decoding body: %w", requestPrefix(ctx), f.Sequence.Load(), err)
 	}
 	// update sequence
 	_ = f.Sequence.Swap(cr.LastSequence)
+	f.pending.Store(cr.Pending)
 	return cr.Results, nil
 }
 
 // sets the sequence for CouchDB from a cold start.
 // gets the most recent sequence to begin following.
 func (f *Follower) coldStartSequence(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", replicateRegistry, nil)
+	info, err := f.Info(ctx)
 	if err != nil {
-		return fmt.Errorf("creating request: %w", err)
+		return err
 	}
-	req.Header.Add(
-		"user-agent", f.UserAgent,
-	)
-	res, err := f.Client.Do(req)
-	if err != nil {
-		return fmt.Errorf("doing request: %w", err)
+	if info.UpdateSequence == 0 {
+		return fmt.Errorf("%s%w", requestPrefix(ctx), ErrInvalidUpdateSequence)
 	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status %v from %s", res.StatusCode, res.Request.URL)
-	}
-	var body struct {
-		UpdateSequence uint64 `json:"update_seq"`
+
+	seq := info.UpdateSequence
+	// approximate a starting point in the past, see SinceApprox
+	if f.sinceApprox > 0 {
+		back := uint64(f.sinceApprox.Seconds() * f.changesPerSecond)
+		seq -= min(back, seq-1)
 	}
-	err = json.NewDecoder(res.Body).Decode(&body)
+	f.Sequence.Store(seq)
+	log.Printf("%scold start: set sequence to %d\n", requestPrefix(ctx), seq)
+	return nil
+}
 
-	if err != nil {
-		return fmt.Errorf("decoding body: %w", err)
+// returns the unpublish metadata (time and versions) for a deleted change
+// by fetching the packument from the registry. npm leaves a tombstone
+// packument with a populated time.unpublished for unpublished packages;
+// security takedowns are replaced by a holding package instead, see
+// registry.Packument.IsHoldingPackage.
+func (f *Follower) GetUnpublished(ctx context.Context, change *CouchDocumentChange) (*registry.Unpublished, error) {
+	if !change.Deleted {
+		return nil, fmt.Errorf("%s: change is not a deletion", change.ID)
 	}
-	if body.UpdateSequence == 0 {
-		return ErrInvalidUpdateSequence
+	p, err := f.GetPackument(ctx, change.ID)
+	if err != nil {
+		return nil, err
 	}
+	return &p.Time.Unpublished, nil
+}
 
-	f.Sequence.Store(body.UpdateSequence)
-	log.Printf("cold start: set sequence to %d\n", body.UpdateSequence)
-	return nil
+// fetches the packument for a change, re-fetching after delay until its
+// _rev matches one of the change's revisions or retries are exhausted.
+// If no match is found, the latest fetched packument is returned together
+// with ErrRevisionMismatch. See the README on update lag for background.
+func (f *Follower) GetPackumentForChange(ctx context.Context, change *CouchDocumentChange, retries int, delay time.Duration) (*registry.Packument, error) {
+	for attempt := 0; ; attempt++ {
+		p, err := f.GetPackument(ctx, change.ID)
+		if err != nil {
+			return nil, err
+		}
+		if change.HasRevision(p.Rev) {
+			return p, nil
+		}
+		if attempt >= retries {
+			return p, fmt.Errorf("%s: %w: got %s after %d attempt(s)", change.ID, ErrRevisionMismatch, p.Rev, attempt+1)
+		}
+		// don't let the packument cache serve the stale revision again
+		f.EvictPackument(change.ID)
+		if err := clock.Sleep(ctx, f.loop.Clock, delay); err != nil {
+			return p, err
+		}
+	}
 }
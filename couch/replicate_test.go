@@ -1,8 +1,21 @@
 package couch
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/kmsec-uk/npm-follower/clock"
+	"github.com/kmsec-uk/npm-follower/registry"
 )
 
 func TestColdStart(t *testing.T) {
@@ -12,3 +25,1142 @@ func TestColdStart(t *testing.T) {
 		t.Errorf("cold start: %v", err)
 	}
 }
+
+func TestDeletedRevs(t *testing.T) {
+	c := CouchDocumentChange{
+		ID:      "pino",
+		Changes: []CouchRevision{{Rev: "2-abc"}, {Rev: "3-def"}},
+	}
+	if revs := c.DeletedRevs(); revs != nil {
+		t.Errorf("expected nil revs for non-deleted change, got %v", revs)
+	}
+	c.Deleted = true
+	revs := c.DeletedRevs()
+	if len(revs) != 2 || revs[0] != "2-abc" || revs[1] != "3-def" {
+		t.Errorf("unexpected deleted revs: %v", revs)
+	}
+}
+
+func TestHasRevisionEmpty(t *testing.T) {
+	c := CouchDocumentChange{ID: "pino", Changes: []CouchRevision{{Rev: ""}, {Rev: "2-abc"}}}
+	if c.HasRevision("") {
+		t.Error("empty revision should never match")
+	}
+	if !c.HasRevision("2-abc") {
+		t.Error("expected 2-abc to match")
+	}
+}
+
+func TestScopeAndBareName(t *testing.T) {
+	testCases := []struct {
+		id, scope, bare string
+	}{
+		{"pino", "", "pino"},
+		{"@types/node", "types", "node"},
+	}
+	for _, tc := range testCases {
+		c := CouchDocumentChange{ID: tc.id}
+		if c.Scope() != tc.scope || c.BareName() != tc.bare {
+			t.Errorf("%s: got scope %q, bare %q", tc.id, c.Scope(), c.BareName())
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+	d := newDedup(2)
+	a := CouchDocumentChange{ID: "a", Changes: []CouchRevision{{Rev: "1-a"}}}
+	b := CouchDocumentChange{ID: "b", Changes: []CouchRevision{{Rev: "1-b"}}}
+	c := CouchDocumentChange{ID: "c", Changes: []CouchRevision{{Rev: "1-c"}}}
+	if d.Seen(a) || d.Seen(b) {
+		t.Fatal("first sighting reported as duplicate")
+	}
+	if !d.Seen(a) {
+		t.Error("expected a to be a duplicate")
+	}
+	// evicts b, the least recently seen
+	d.Seen(c)
+	if d.Seen(b) {
+		t.Error("expected b to have been evicted")
+	}
+}
+
+func TestRequestTimeoutOrDefault(t *testing.T) {
+	testCases := []struct {
+		name     string
+		interval time.Duration
+		explicit time.Duration
+		want     time.Duration
+	}{
+		{name: "short interval", interval: 5 * time.Second, want: 4500 * time.Millisecond},
+		{name: "long interval capped", interval: time.Minute, want: 10 * time.Second},
+		{name: "explicit override", interval: 5 * time.Second, explicit: 30 * time.Second, want: 30 * time.Second},
+	}
+	for _, tc := range testCases {
+		f := NewFollower().WithPollingInterval(tc.interval).WithRequestTimeout(tc.explicit)
+		if got := f.requestTimeoutOrDefault(); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMinInterval(t *testing.T) {
+	f := NewFollower().WithPollingInterval(10 * time.Millisecond)
+	if got := f.interval(); got != time.Second {
+		t.Errorf("expected interval clamped to 1s, got %v", got)
+	}
+	f.WithPollingIntervalUnsafe(10 * time.Millisecond)
+	if got := f.interval(); got != 10*time.Millisecond {
+		t.Errorf("expected unsafe interval to bypass floor, got %v", got)
+	}
+	f.WithMinInterval(100 * time.Millisecond).WithPollingInterval(200 * time.Millisecond)
+	if got := f.interval(); got != 200*time.Millisecond {
+		t.Errorf("expected interval above custom floor to be kept, got %v", got)
+	}
+	f.WithPollingInterval(0).WithPollingIntervalUnsafe(-time.Second)
+	if got := f.interval(); got != 200*time.Millisecond {
+		t.Errorf("expected non-positive intervals to be ignored, got %v", got)
+	}
+	// the floor is applied at Connect, so it can be lowered afterwards
+	f = NewFollower().Since(10).WithPollingInterval(10 * time.Millisecond).WithMinInterval(0)
+	f.WithDoer(&stubDoer{})
+	f.Connect(t.Context())
+	defer f.Stop()
+	if f.loop.Interval != 10*time.Millisecond {
+		t.Errorf("expected the floor to be lowered after the interval was set, got %v", f.loop.Interval)
+	}
+}
+
+func TestBufferSize(t *testing.T) {
+	for n, want := range map[int]int{-1: 0, 0: 0, 5: 5} {
+		f := NewFollower().Since(1).WithBufferSize(n).WithClock(clock.NewFake(time.Now()))
+		f.WithDoer(&stubDoer{})
+		results := f.Connect(t.Context())
+		if got := cap(results); got != want {
+			t.Errorf("WithBufferSize(%d): got capacity %d, want %d", n, got, want)
+		}
+		f.Stop()
+		for range results {
+		}
+	}
+}
+
+func TestJSONLinesRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewJSONLinesWriter(&buf)
+	observed := time.Date(2025, 12, 21, 10, 8, 22, 0, time.UTC)
+	in := []Result{
+		{Change: CouchDocumentChange{Seq: 1, ID: "pino", Changes: []CouchRevision{{Rev: "1-a"}}}, ObservedAt: observed},
+		{Error: errors.New("boom")},
+	}
+	for _, r := range in {
+		if err := w.Encode(r); err != nil {
+			t.Fatalf("encoding: %v", err)
+		}
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Fatalf("expected 2 lines, got %d", lines)
+	}
+	r := NewJSONLinesReader(&buf)
+	first, ts, err := r.Decode()
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if first.Change.ID != "pino" || !first.Change.HasRevision("1-a") || !ts.Equal(observed) || !first.ObservedAt.Equal(observed) {
+		t.Errorf("unexpected first result: %+v at %v", first, ts)
+	}
+	// without an ObservedAt the record is stamped when written
+	second, ts, err := r.Decode()
+	if err != nil || second.Error == nil || second.Error.Error() != "boom" || ts.IsZero() {
+		t.Errorf("unexpected second result: %+v at %v, %v", second, ts, err)
+	}
+	if _, _, err := r.Decode(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+// serves canned _changes responses in order, then empty batches, along
+// with packuments from the registry host and database info from the
+// database root. Each _changes request is recorded in requests and times.
+type stubDoer struct {
+	mu        sync.Mutex
+	responses []CouchResponse
+	requests  []*http.Request
+	times     []time.Time
+	// fail the first failures _changes requests with a connection error
+	failures int
+	// if set, times are read from clock, which is advanced by delay on
+	// each _changes request to simulate slow fetches
+	clock *clock.Fake
+	delay time.Duration
+	// packument bodies by package, served in order with the last one
+	// repeating. Defaults to {"name": <id>, "_rev": "1-a"}
+	packuments map[string][]string
+	// packument fetches served so far, by package
+	fetches map[string]int
+	// package whose packument fetch hangs until the request is cancelled
+	hang string
+	// served for requests to the database root
+	info DBInfo
+}
+
+func (d *stubDoer) Do(req *http.Request) (*http.Response, error) {
+	switch {
+	case req.URL.Host == "registry.npmjs.com":
+		return d.packument(req)
+	case !strings.HasSuffix(req.URL.Path, "/_changes"):
+		return jsonResponse(req, d.info)
+	}
+	d.mu.Lock()
+	now := time.Now()
+	if d.clock != nil {
+		now = d.clock.Now()
+	}
+	d.times = append(d.times, now)
+	fail := len(d.times) <= d.failures
+	if !fail {
+		d.requests = append(d.requests, req)
+	}
+	served := len(d.requests)
+	d.mu.Unlock()
+	if d.clock != nil && d.delay > 0 {
+		d.clock.Advance(d.delay)
+	}
+	if fail {
+		return nil, errors.New("connection reset")
+	}
+	cr := CouchResponse{Results: []CouchDocumentChange{}}
+	if served <= len(d.responses) {
+		cr = d.responses[served-1]
+	} else if since, err := strconv.ParseUint(req.URL.Query().Get("since"), 10, 64); err == nil {
+		cr.LastSequence = since
+	}
+	return jsonResponse(req, cr)
+}
+
+func (d *stubDoer) packument(req *http.Request) (*http.Response, error) {
+	id := strings.TrimPrefix(req.URL.Path, "/")
+	if id == d.hang && id != "" {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	d.mu.Lock()
+	if d.fetches == nil {
+		d.fetches = map[string]int{}
+	}
+	d.fetches[id]++
+	n := d.fetches[id]
+	bodies := d.packuments[id]
+	d.mu.Unlock()
+	body := `{"name": "` + id + `", "_rev": "1-a"}`
+	if len(bodies) > 0 {
+		body = bodies[min(n, len(bodies))-1]
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func jsonResponse(req *http.Request, v any) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// receives the next value from ch, failing the test if none arrives
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a result")
+	}
+	panic("unreachable")
+}
+
+func TestConnectTwice(t *testing.T) {
+	// unbuffered, so a blocking ErrAlreadyConnected send would hang Connect
+	f := NewFollower().Since(1).WithBufferSize(0).WithClock(clock.NewFake(time.Now()))
+	f.WithDoer(&stubDoer{})
+	first := f.Connect(t.Context())
+	second := f.Connect(t.Context())
+	r, ok := <-second
+	if !ok || !errors.Is(r.Error, ErrAlreadyConnected) {
+		t.Errorf("expected ErrAlreadyConnected, got %+v", r)
+	}
+	if _, ok := <-second; ok {
+		t.Error("expected second channel to be closed")
+	}
+	f.Stop()
+	for range first {
+	}
+	// reconnecting after the first stream ended is allowed
+	third := f.Connect(t.Context())
+	f.Stop()
+	for r := range third {
+		if errors.Is(r.Error, ErrAlreadyConnected) {
+			t.Error("unexpected ErrAlreadyConnected after Stop")
+		}
+	}
+}
+
+func TestDatabaseURL(t *testing.T) {
+	if u := NewFollower().databaseURL(); u != "https://replicate.npmjs.com/registry/" {
+		t.Errorf("unexpected default database URL %s", u)
+	}
+	d := &stubDoer{}
+	f := NewFollower().Since(1).WithReplicateURL("https://couch.example.com/").WithDatabase("npm")
+	f.WithDoer(d)
+	if _, err := f.getChanges(t.Context()); err != nil {
+		t.Fatalf("getting changes: %v", err)
+	}
+	if u := d.requests[0].URL; u.Host != "couch.example.com" || u.Path != "/npm/_changes" {
+		t.Errorf("unexpected request URL %s", d.requests[0].URL)
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	observed := time.Date(2025, 12, 15, 15, 42, 43, 0, time.UTC)
+	change := CouchDocumentChange{Seq: 42, ID: "@scope/pkg", Changes: []CouchRevision{{Rev: "3-abc"}}, Deleted: true}
+
+	var buf bytes.Buffer
+	if err := NewJSONLinesWriter(&buf).EncodeArchive(NewArchiveRecord(change, observed)); err != nil {
+		t.Fatalf("encoding: %v", err)
+	}
+	want := `{"v":1,"package":"@scope/pkg","seq":42,"revs":["3-abc"],"deleted":true,"observed_at":"2025-12-15T15:42:43Z"}` + "\n"
+	if buf.String() != want {
+		t.Errorf("unexpected encoding:\n got %s\nwant %s", buf.String(), want)
+	}
+
+	a, err := NewJSONLinesReader(&buf).DecodeArchive()
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	got := a.Change()
+	if got.ID != change.ID || got.Seq != change.Seq || !got.Deleted || !got.HasRevision("3-abc") || !a.ObservedAt.Equal(observed) {
+		t.Errorf("round trip mismatch: %+v", a)
+	}
+}
+
+func TestGapDetection(t *testing.T) {
+	d := &stubDoer{responses: []CouchResponse{{
+		Results: []CouchDocumentChange{
+			{Seq: 11, ID: "a"},
+			{Seq: 12, ID: "b"},
+			{Seq: 15, ID: "c"},
+		},
+		LastSequence: 15,
+	}}}
+	f := NewFollower().Since(10).WithGapDetection().WithClock(clock.NewFake(time.Now()))
+	f.WithDoer(d)
+	results := f.Connect(t.Context())
+	defer f.Stop()
+
+	var got []string
+	for range 4 {
+		r := <-results
+		if r.Error != nil {
+			if !errors.Is(r.Error, ErrSequenceGap) {
+				t.Fatalf("unexpected error: %v", r.Error)
+			}
+			got = append(got, "gap")
+			continue
+		}
+		got = append(got, r.Change.ID)
+	}
+	if want := []string{"a", "b", "gap", "c"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGapDetectionWithDedup(t *testing.T) {
+	d := &stubDoer{responses: []CouchResponse{{
+		Results: []CouchDocumentChange{
+			{Seq: 11, ID: "a", Changes: []CouchRevision{{Rev: "1-a"}}},
+			{Seq: 12, ID: "a", Changes: []CouchRevision{{Rev: "1-a"}}},
+			{Seq: 13, ID: "b", Changes: []CouchRevision{{Rev: "1-b"}}},
+		},
+		LastSequence: 13,
+	}}}
+	f := NewFollower().Since(10).WithDedup(10).WithGapDetection().WithClock(clock.NewFake(time.Now()))
+	f.WithDoer(d)
+	results := f.Connect(t.Context())
+	defer f.Stop()
+
+	// the duplicate at 12 is dropped without leaving a false gap before 13
+	for _, want := range []string{"a", "b"} {
+		if r := <-results; r.Error != nil || r.Change.ID != want {
+			t.Fatalf("expected %s, got %+v", want, r)
+		}
+	}
+}
+
+func TestEnrichDelay(t *testing.T) {
+	d := &stubDoer{responses: []CouchResponse{{
+		Results:      []CouchDocumentChange{{Seq: 11, ID: "pino", Changes: []CouchRevision{{Rev: "1-a"}}}},
+		LastSequence: 11,
+	}}}
+	fake := clock.NewFake(time.Now())
+	f := NewFollower().Since(10).WithEnrichDelay(10 * time.Second).WithClock(fake)
+	f.WithDoer(d)
+	results := f.ConnectWithPackuments(t.Context())
+	defer f.Stop()
+
+	// the polling ticker and the enrich delay
+	fake.BlockUntil(2)
+	select {
+	case r := <-results:
+		t.Fatalf("result delivered before delay: %+v", r)
+	default:
+	}
+	fake.Advance(10 * time.Second)
+	if r := receive(t, results); r.Error != nil || r.Packument == nil || r.Packument.Name != "pino" {
+		t.Errorf("unexpected result: %+v", r)
+	}
+}
+
+func TestVersionFilter(t *testing.T) {
+	d := &stubDoer{failures: 1, responses: []CouchResponse{{
+		Results: []CouchDocumentChange{
+			{Seq: 11, ID: "stable", Changes: []CouchRevision{{Rev: "1-a"}}},
+			{Seq: 12, ID: "beta", Changes: []CouchRevision{{Rev: "1-a"}}},
+			{Seq: 13, ID: "gone", Changes: []CouchRevision{{Rev: "2-b"}}, Deleted: true},
+		},
+		LastSequence: 13,
+	}}, packuments: map[string][]string{
+		"stable": {`{"name": "stable", "_rev": "1-a", "versions": {"1.0.0": {"version": "1.0.0"}}, "time": {"1.0.0": "2025-12-21T10:00:00.000Z"}}`},
+		"beta":   {`{"name": "beta", "_rev": "1-a", "versions": {"2.0.0-beta.1": {"version": "2.0.0-beta.1"}}, "time": {"2.0.0-beta.1": "2025-12-21T10:00:00.000Z"}}`},
+	}}
+	fake := clock.NewFake(time.Now())
+	f := NewFollower().Since(10).WithClock(fake).WithVersionFilter(func(v string) bool { return !registry.IsPrerelease(v) })
+	f.WithDoer(d)
+	results := f.ConnectWithPackuments(t.Context())
+	defer f.Stop()
+
+	// the first poll fails, the second returns the batch
+	fake.BlockUntil(1)
+	fake.Advance(2 * time.Second)
+	var got []string
+	for range 3 {
+		r := receive(t, results)
+		if r.Error != nil {
+			got = append(got, "error")
+			continue
+		}
+		got = append(got, r.Change.ID)
+	}
+	// the prerelease is dropped, the poll error and deletion pass through
+	if want := []string{"error", "stable", "gone"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDescending(t *testing.T) {
+	d := &stubDoer{responses: []CouchResponse{{
+		Results: []CouchDocumentChange{
+			{Seq: 30, ID: "c"},
+			{Seq: 25, ID: "b"},
+		},
+		LastSequence: 25,
+	}, {
+		Results: []CouchDocumentChange{
+			{Seq: 20, ID: "a"},
+			{Seq: 5, ID: "old"},
+		},
+		LastSequence: 5,
+	}}}
+	fake := clock.NewFake(time.Now())
+	f := NewFollower().Since(30).WithDescending(10).WithLimit(2).WithClock(fake)
+	f.WithDoer(d)
+	results := f.Connect(t.Context())
+	defer f.Stop()
+
+	for _, want := range []string{"c", "b"} {
+		if r := receive(t, results); r.Error != nil || r.Change.ID != want {
+			t.Fatalf("expected %s, got %+v", want, r)
+		}
+	}
+	fake.BlockUntil(1)
+	fake.Advance(2 * time.Second)
+	if r := receive(t, results); r.Error != nil || r.Change.ID != "a" {
+		t.Fatalf("expected a, got %+v", r)
+	}
+	// the change below the floor is dropped and the scan ends
+	select {
+	case r, ok := <-results:
+		if ok {
+			t.Errorf("expected the channel to close at the floor, got %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed at the floor")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if q := d.requests[1].URL.Query(); q.Get("descending") != "true" || q.Get("since") != "25" || q.Get("limit") != "2" {
+		t.Errorf("unexpected query %v", q)
+	}
+}
+
+func TestSinceApprox(t *testing.T) {
+	testCases := []struct {
+		name string
+		d    time.Duration
+		rate float64
+		want uint64
+	}{
+		{"default rate", 10 * time.Second, defaultChangesPerSecond, 950},
+		{"custom rate", time.Second, 100, 900},
+		{"clamped to the first change", time.Hour, defaultChangesPerSecond, 1},
+	}
+	for _, tc := range testCases {
+		f := NewFollower().SinceApprox(tc.d).WithChangeRate(tc.rate)
+		f.WithDoer(&stubDoer{info: DBInfo{UpdateSequence: 1000}})
+		if err := f.coldStartSequence(t.Context()); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := f.Sequence.Load(); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSequenceAdvancement(t *testing.T) {
+	testCases := []struct {
+		name     string
+		response CouchResponse
+		want     []string
+	}{
+		{
+			name: "multiple changes",
+			response: CouchResponse{
+				Results: []CouchDocumentChange{
+					{Seq: 11, ID: "a"},
+					{Seq: 12, ID: "b"},
+					{Seq: 14, ID: "c"},
+				},
+				LastSequence: 14,
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "single change",
+			response: CouchResponse{
+				Results:      []CouchDocumentChange{{Seq: 20, ID: "pino", Deleted: true}},
+				LastSequence: 20,
+			},
+			want: []string{"pino"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &stubDoer{responses: []CouchResponse{tc.response}}
+			now := time.Date(2025, 12, 15, 15, 42, 43, 0, time.UTC)
+			f := NewFollower().Since(10).WithClock(clock.NewFake(now))
+			f.WithDoer(d)
+			results := f.Connect(t.Context())
+			defer f.Stop()
+
+			var got []string
+			for range tc.want {
+				r := <-results
+				if r.Error != nil {
+					t.Fatalf("unexpected error: %v", r.Error)
+				}
+				if !r.ObservedAt.Equal(now) {
+					t.Errorf("ObservedAt: got %v, want %v", r.ObservedAt, now)
+				}
+				got = append(got, r.Change.ID)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if seq := f.Sequence.Load(); seq != tc.response.LastSequence {
+				t.Errorf("sequence: got %d, want %d", seq, tc.response.LastSequence)
+			}
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if since := d.requests[0].URL.Query().Get("since"); since != "10" {
+				t.Errorf("since: got %q, want 10", since)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	v1 := &registry.Packument{
+		Versions: map[string]registry.PackageVersion{"1.0.0": {}},
+		DistTags: map[string]string{"latest": "1.0.0"},
+	}
+	v2 := &registry.Packument{
+		Versions: map[string]registry.PackageVersion{"1.0.0": {}, "2.0.0": {}},
+		DistTags: map[string]string{"latest": "2.0.0"},
+	}
+	retagged := &registry.Packument{
+		Versions: map[string]registry.PackageVersion{"1.0.0": {}},
+		DistTags: map[string]string{"latest": "1.0.0", "next": "1.0.0"},
+	}
+	holding := &registry.Packument{
+		Description: "security holding package",
+		Versions:    map[string]registry.PackageVersion{"0.0.1-security": {}},
+	}
+	unpublished := &registry.Packument{Time: registry.Time{Unpublished: registry.Unpublished{Time: "2025-12-01T00:00:00.000Z"}}}
+	established := &registry.Packument{
+		Versions: v2.Versions,
+		Time:     registry.Time{Created: "2020-01-01T00:00:00.000Z", Modified: "2025-12-21T10:00:00.000Z"},
+	}
+
+	testCases := []struct {
+		name      string
+		deleted   bool
+		prev, cur *registry.Packument
+		want      ChangeKind
+	}{
+		{"new package", false, nil, v1, ChangeNewPackage},
+		{"first seen established package", false, nil, established, ChangeUnknown},
+		{"new version", false, v1, v2, ChangeNewVersion},
+		{"dist-tag", false, v1, retagged, ChangeDistTag},
+		{"metadata", false, v1, v1, ChangeOther},
+		{"holding", false, v1, holding, ChangeSecurityHolding},
+		{"unpublished", true, v1, unpublished, ChangeUnpublished},
+		{"deleted", true, v1, &registry.Packument{}, ChangeDeleted},
+	}
+	for _, tc := range testCases {
+		change := &CouchDocumentChange{ID: "pino", Deleted: tc.deleted}
+		if got := classify(change, tc.prev, tc.cur); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	d := &stubDoer{responses: []CouchResponse{{
+		Results:      []CouchDocumentChange{{Seq: 11, ID: "pino"}},
+		LastSequence: 11,
+	}}, failures: 2, clock: fake}
+	var reconnected []uint64
+	f := NewFollower().Since(10).WithClock(fake).
+		WithPollingInterval(time.Second).
+		WithBackoff(5*time.Second, 10*time.Second).
+		WithOnReconnect(func(lastSeq uint64) { reconnected = append(reconnected, lastSeq) })
+	f.WithDoer(d)
+	results := f.Connect(t.Context())
+	defer f.Stop()
+
+	// ticks that fall due while backing off are skipped
+	for _, backoff := range []time.Duration{5 * time.Second, 10 * time.Second} {
+		if r := receive(t, results); r.Error == nil {
+			t.Fatalf("expected error, got %+v", r)
+		}
+		fake.Advance(backoff - time.Second)
+		fake.Advance(time.Second)
+	}
+	if r := receive(t, results); r.Error != nil || r.Change.ID != "pino" {
+		t.Fatalf("expected change after reconnect, got %+v", r)
+	}
+	if !slices.Equal(reconnected, []uint64{10}) {
+		t.Errorf("expected a single reconnect from 10, got %v", reconnected)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, want := range []time.Duration{5 * time.Second, 10 * time.Second} {
+		if gap := d.times[i+1].Sub(d.times[i]); gap != want {
+			t.Errorf("attempt %d after %v, want %v", i+2, gap, want)
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	f := NewFollower().WithBackoff(time.Second, 10*time.Second)
+	for n, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 50: 10 * time.Second} {
+		if got := f.backoff(n); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestIsNewPackage(t *testing.T) {
+	one := map[string]registry.PackageVersion{"1.0.0": {}}
+	two := map[string]registry.PackageVersion{"1.0.0": {}, "1.0.1": {}}
+	testCases := []struct {
+		name string
+		p    *registry.Packument
+		want bool
+	}{
+		{"single version", &registry.Packument{Versions: one}, true},
+		{"created with two versions", &registry.Packument{Versions: two, Time: registry.Time{
+			Created: "2025-12-01T00:00:00.000Z", Modified: "2025-12-01T00:00:10.000Z",
+		}}, true},
+		{"established", &registry.Packument{Versions: two, Time: registry.Time{
+			Created: "2020-01-01T00:00:00.000Z", Modified: "2025-12-01T00:00:00.000Z",
+		}}, false},
+		{"holding package", &registry.Packument{
+			Description: "security holding package",
+			Versions:    map[string]registry.PackageVersion{"0.0.1-security": {}},
+		}, false},
+	}
+	for _, tc := range testCases {
+		if got := isNewPackage(tc.p); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestConnectNewPackagesOnly(t *testing.T) {
+	d := &stubDoer{failures: 1, responses: []CouchResponse{{
+		Results: []CouchDocumentChange{
+			{Seq: 11, ID: "established", Changes: []CouchRevision{{Rev: "1-a"}}},
+			{Seq: 12, ID: "fresh", Changes: []CouchRevision{{Rev: "1-a"}}},
+			{Seq: 13, ID: "taken", Changes: []CouchRevision{{Rev: "1-a"}}},
+			{Seq: 14, ID: "gone", Changes: []CouchRevision{{Rev: "2-b"}}, Deleted: true},
+		},
+		LastSequence: 14,
+	}}, packuments: map[string][]string{
+		"established": {`{"name": "established", "_rev": "1-a", "versions": {"1.0.0": {}, "1.0.1": {}}, "time": {"created": "2020-01-01T00:00:00.000Z", "modified": "2025-12-21T10:00:00.000Z"}}`},
+		"fresh":       {`{"name": "fresh", "_rev": "1-a", "versions": {"0.0.1": {}}}`},
+		"taken":       {`{"name": "taken", "_rev": "1-a", "description": "security holding package", "versions": {"0.0.1-security": {}}}`},
+	}}
+	fake := clock.NewFake(time.Now())
+	f := NewFollower().Since(10).WithClock(fake)
+	f.WithDoer(d)
+	results := f.ConnectNewPackagesOnly(t.Context())
+	defer f.Stop()
+
+	// the first poll fails, the second returns the batch
+	fake.BlockUntil(1)
+	fake.Advance(2 * time.Second)
+	if r := receive(t, results); r.Error == nil {
+		t.Fatalf("expected the poll error to pass through, got %+v", r)
+	}
+	if r := receive(t, results); r.Error != nil || r.Change.ID != "fresh" {
+		t.Fatalf("expected fresh, got %+v", r)
+	}
+	// established, holding and deleted packages are all dropped
+	select {
+	case r := <-results:
+		t.Errorf("unexpected result: %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestGetPackumentForChange(t *testing.T) {
+	d := &stubDoer{packuments: map[string][]string{"pino": {
+		`{"name": "pino", "_rev": "1-a"}`,
+		`{"name": "pino", "_rev": "2-b"}`,
+	}}}
+	fake := clock.NewFake(time.Now())
+	f := NewFollower().WithClock(fake)
+	f.WithDoer(d).WithPackumentCache(time.Minute, 10)
+	change := &CouchDocumentChange{ID: "pino", Changes: []CouchRevision{{Rev: "2-b"}}}
+
+	// the stale revision is evicted from the cache and refetched after the delay
+	type fetched struct {
+		p   *registry.Packument
+		err error
+	}
+	done := make(chan fetched)
+	go func() {
+		p, err := f.GetPackumentForChange(t.Context(), change, 1, time.Second)
+		done <- fetched{p, err}
+	}()
+	fake.BlockUntil(1)
+	fake.Advance(time.Second)
+	r := receive(t, done)
+	if r.err != nil || r.p.Rev != "2-b" {
+		t.Fatalf("expected 2-b after a retry, got %v, %v", r.p, r.err)
+	}
+	if n := d.fetches["pino"]; n != 2 {
+		t.Errorf("expected 2 fetches, got %d", n)
+	}
+
+	// with retries exhausted the latest packument is returned with the error
+	change = &CouchDocumentChange{ID: "pino", Changes: []CouchRevision{{Rev: "3-c"}}}
+	p, err := f.GetPackumentForChange(t.Context(), change, 0, time.Second)
+	if !errors.Is(err, ErrRevisionMismatch) || p == nil || p.Rev != "2-b" {
+		t.Errorf("expected ErrRevisionMismatch with 2-b, got %v, %v", p, err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	d := &stubDoer{}
+	f := NewFollower().Since(10).WithFilter("app/by_scope", map[string]string{"scope": "types"})
+	f.WithDoer(d)
+	if _, err := f.getChanges(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	q := d.requests[0].URL.Query()
+	if q.Get("filter") != "app/by_scope" || q.Get("scope") != "types" || q.Get("since") != "10" {
+		t.Errorf("unexpected query %v", q)
+	}
+	// a selector takes precedence and drops the filter params
+	f.WithSelector(map[string]any{"name": "pino"})
+	if _, err := f.getChanges(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if q := d.requests[1].URL.Query(); q.Get("filter") != "_selector" || q.Has("scope") {
+		t.Errorf("unexpected query with selector %v", q)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	d := &stubDoer{failures: 1}
+	f := NewFollower().Since(10).WithRequestID(func() string { return "abc" })
+	f.WithDoer(d)
+	ctx := f.withRequestID(t.Context())
+	if id := RequestID(ctx); id != "abc" {
+		t.Fatalf("expected request id abc, got %q", id)
+	}
+	if _, err := f.getChanges(ctx); err == nil || !strings.HasPrefix(err.Error(), "request abc: ") {
+		t.Errorf("expected the error to be prefixed with the request id, got %v", err)
+	}
+	if _, err := f.getChanges(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.requests[0].Header.Get("x-request-id"); got != "abc" {
+		t.Errorf("expected x-request-id abc, got %q", got)
+	}
+	// without a generator no id is attached
+	f.WithRequestID(nil)
+	if RequestID(f.withRequestID(t.Context())) != "" {
+		t.Error("expected no request id without a generator")
+	}
+}
+
+func TestSkippedTicks(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	// every fetch takes three and a half polling intervals
+	d := &stubDoer{responses: []CouchResponse{
+		{Results: []CouchDocumentChange{{Seq: 11, ID: "a"}}, LastSequence: 11},
+		{Results: []CouchDocumentChange{{Seq: 12, ID: "b"}}, LastSequence: 12},
+	}, clock: fake, delay: 3500 * time.Millisecond}
+	f := NewFollower().Since(10).WithPollingInterval(time.Second).WithClock(fake)
+	f.WithDoer(d)
+	results := f.Connect(t.Context())
+	defer f.Stop()
+
+	// the second change is only fetched once the first slow fetch has been
+	// accounted for
+	for range 2 {
+		if r := receive(t, results); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+	}
+	if n := f.SkippedTicks(); n < 3 {
+		t.Errorf("expected at least 3 skipped ticks, got %d", n)
+	}
+	// the slow fetches ran back to back, never overlapping
+	d.mu.Lock()
+	if gap := d.times[1].Sub(d.times[0]); gap < 3500*time.Millisecond {
+		t.Errorf("second fetch started %v after the first, before it finished", gap)
+	}
+	d.mu.Unlock()
+
+	// drift correction waits out the interval instead of skipping ticks
+	fake = clock.NewFake(time.Now())
+	d = &stubDoer{responses: []CouchResponse{
+		{Results: []CouchDocumentChange{{Seq: 11, ID: "a"}}, LastSequence: 11},
+		{Results: []CouchDocumentChange{{Seq: 12, ID: "b"}}, LastSequence: 12},
+	}, clock: fake, delay: 3500 * time.Millisecond}
+	f = NewFollower().Since(10).WithPollingInterval(time.Second).WithDriftCorrection().WithClock(fake)
+	f.WithDoer(d)
+	results = f.Connect(t.Context())
+	defer f.Stop()
+	for range 2 {
+		receive(t, results)
+	}
+	if n := f.SkippedTicks(); n != 0 {
+		t.Errorf("expected no skipped ticks with drift correction, got %d", n)
+	}
+}
+
+func TestPending(t *testing.T) {
+	d := &stubDoer{responses: []CouchResponse{
+		{Results: []CouchDocumentChange{{Seq: 11, ID: "a"}}, LastSequence: 11, Pending: 42},
+		{Results: []CouchDocumentChange{{Seq: 12, ID: "b"}}, LastSequence: 12},
+	}}
+	f := NewFollower().Since(10)
+	f.WithDoer(d)
+	if f.Pending() != 0 {
+		t.Errorf("expected no pending changes before polling, got %d", f.Pending())
+	}
+	for _, want := range []uint64{42, 0} {
+		if _, err := f.getChanges(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Pending(); got != want {
+			t.Errorf("got %d pending, want %d", got, want)
+		}
+	}
+}
+
+func TestPollStatus(t *testing.T) {
+	d := &stubDoer{failures: 1, responses: []CouchResponse{{
+		Results:      []CouchDocumentChange{{Seq: 11, ID: "a"}, {Seq: 12, ID: "b"}},
+		LastSequence: 12,
+	}}}
+	fake := clock.NewFake(time.Now())
+	f := NewFollower().Since(10).WithClock(fake)
+	f.WithDoer(d)
+	results := f.Connect(t.Context())
+	defer f.Stop()
+
+	if r := receive(t, results); r.Error == nil {
+		t.Fatalf("expected the first poll to fail, got %+v", r)
+	}
+	if f.LastError() == nil || !f.LastPollTime().IsZero() || f.LastPollCount() != 0 {
+		t.Errorf("unexpected status after a failed poll: %v, %v, %d", f.LastError(), f.LastPollTime(), f.LastPollCount())
+	}
+	fake.BlockUntil(1)
+	fake.Advance(2 * time.Second)
+	receive(t, results)
+	receive(t, results)
+	if f.LastError() != nil || !f.LastPollTime().Equal(fake.Now()) || f.LastPollCount() != 2 {
+		t.Errorf("unexpected status after a successful poll: %v, %v, %d", f.LastError(), f.LastPollTime(), f.LastPollCount())
+	}
+}
+
+func TestInfo(t *testing.T) {
+	want := DBInfo{DBName: "registry", DocCount: 3500000, DocDelCount: 100, UpdateSequence: 1000}
+	f := NewFollower()
+	f.WithDoer(&stubDoer{info: want})
+	info, err := f.Info(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *info != want {
+		t.Errorf("got %+v, want %+v", *info, want)
+	}
+}
+
+func TestGetUnpublished(t *testing.T) {
+	f := NewFollower()
+	f.WithDoer(&stubDoer{packuments: map[string][]string{
+		"gone": {`{"name": "gone", "_rev": "3-c", "time": {"created": "2025-12-01T00:00:00.000Z", "unpublished": {"time": "2025-12-21T10:00:00.000Z", "versions": ["1.0.0", "1.0.1"]}}}`},
+	}})
+	change := &CouchDocumentChange{ID: "gone", Deleted: true, Changes: []CouchRevision{{Rev: "3-c"}}}
+	u, err := f.GetUnpublished(t.Context(), change)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Time != "2025-12-21T10:00:00.000Z" || !slices.Equal(u.Versions, []string{"1.0.0", "1.0.1"}) {
+		t.Errorf("unexpected unpublish metadata: %+v", u)
+	}
+	// only deletions carry unpublish metadata
+	change.Deleted = false
+	if _, err := f.GetUnpublished(t.Context(), change); err == nil {
+		t.Error("expected an error for a change that is not a deletion")
+	}
+}
+
+func TestSelector(t *testing.T) {
+	d := &stubDoer{}
+	f := NewFollower().Since(10).WithSelector(map[string]any{"name": "pino"})
+	f.WithDoer(d)
+	if _, err := f.getChanges(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	req := d.requests[0]
+	if req.Method != "POST" || req.URL.Query().Get("filter") != "_selector" {
+		t.Errorf("unexpected request: %s %s", req.Method, req.URL)
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"selector":{"name":"pino"}}` {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
+
+func TestSinceBeginning(t *testing.T) {
+	d := &stubDoer{responses: []CouchResponse{{
+		Results:      []CouchDocumentChange{{Seq: 1, ID: "first"}},
+		LastSequence: 1,
+	}}}
+	f := NewFollower().SinceBeginning().WithLimit(1).WithClock(clock.NewFake(time.Now()))
+	f.WithDoer(d)
+	results := f.Connect(t.Context())
+	defer f.Stop()
+	if r := <-results; r.Error != nil || r.Change.ID != "first" {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	req := d.requests[0]
+	if !strings.HasSuffix(req.URL.Path, "/_changes") || req.URL.Query().Get("since") != "0" {
+		t.Errorf("expected _changes since 0 without cold start, got %s", req.URL)
+	}
+}
+
+func TestDriftCorrection(t *testing.T) {
+	start := time.Date(2025, 12, 15, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	d := &stubDoer{clock: fake, delay: 1500 * time.Millisecond}
+	f := NewFollower().Since(10).WithDriftCorrection().WithClock(fake)
+	f.WithDoer(d)
+	f.Connect(t.Context())
+	defer f.Stop()
+
+	// each 1.5s fetch is followed by a 500ms sleep, alongside the ticker
+	for range 2 {
+		fake.BlockUntil(2)
+		fake.Advance(500 * time.Millisecond)
+	}
+	fake.BlockUntil(2)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.times) != 3 {
+		t.Fatalf("expected 3 polls, got %d", len(d.times))
+	}
+	// each poll starts one interval after the last despite the slow fetch
+	for i := 1; i < 3; i++ {
+		if gap := d.times[i].Sub(d.times[i-1]); gap != 2*time.Second {
+			t.Errorf("poll %d started %v after the previous, want 2s", i+1, gap)
+		}
+	}
+}
+
+func TestOnError(t *testing.T) {
+	d := &stubDoer{responses: []CouchResponse{{
+		Results:      []CouchDocumentChange{{Seq: 11, ID: "pino"}},
+		LastSequence: 11,
+	}}, failures: 2}
+	errs := make(chan error, 2)
+	fake := clock.NewFake(time.Now())
+	f := NewFollower().Since(10).WithClock(fake).
+		WithOnError(func(err error) { errs <- err }).
+		WithoutErrorResults()
+	f.WithDoer(d)
+	results := f.Connect(t.Context())
+	defer f.Stop()
+
+	// errors go to the hook only, the first Result is the change
+	for range 2 {
+		receive(t, errs)
+		fake.Advance(2 * time.Second)
+	}
+	if r := receive(t, results); r.Error != nil || r.Change.ID != "pino" {
+		t.Fatalf("expected change, got %+v", r)
+	}
+}
+
+func TestChangeRate(t *testing.T) {
+	d := &stubDoer{responses: []CouchResponse{{
+		Results:      []CouchDocumentChange{{Seq: 11, ID: "a"}, {Seq: 12, ID: "b"}, {Seq: 13, ID: "c"}},
+		LastSequence: 13,
+	}}}
+	fake := clock.NewFake(time.Now())
+	// drift correction parks the loop on the clock once a poll has been
+	// recorded, which gives the test something to wait for
+	f := NewFollower().Since(10).WithClock(fake).WithDriftCorrection()
+	f.WithDoer(d)
+	results := f.Connect(t.Context())
+	defer f.Stop()
+	for range 3 {
+		receive(t, results)
+	}
+	// the ticker and the drift-corrected sleep
+	fake.BlockUntil(2)
+	if got, want := f.ChangeRate(), 3/changeRateWindow.Seconds(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// the batch ages out of the window
+	fake.Advance(changeRateWindow + time.Second)
+	if got := f.ChangeRate(); got != 0 {
+		t.Errorf("expected 0 after the window, got %v", got)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	results := make(chan Result, 2)
+	results <- Result{Change: CouchDocumentChange{Seq: 1, ID: "pino"}}
+	results <- Result{Change: CouchDocumentChange{Seq: 2, ID: "@types/node"}}
+	close(results)
+
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	errc := make(chan error, 1)
+	go func() { errc <- rec.Consume(t.Context(), results) }()
+	var forwarded []string
+	for r := range rec.Results() {
+		forwarded = append(forwarded, r.Change.ID)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"pino", "@types/node"}; !slices.Equal(forwarded, want) {
+		t.Errorf("forwarded %v, want %v", forwarded, want)
+	}
+	// the recording replays the same changes
+	r := NewJSONLinesReader(&buf)
+	for _, want := range forwarded {
+		got, _, err := r.Decode()
+		if err != nil || got.Change.ID != want {
+			t.Errorf("replay: got %+v, %v, want %s", got, err, want)
+		}
+	}
+}
+
+func TestEnrichTimeout(t *testing.T) {
+	d := &stubDoer{responses: []CouchResponse{{
+		Results: []CouchDocumentChange{
+			{Seq: 11, ID: "slow", Changes: []CouchRevision{{Rev: "1-a"}}},
+			{Seq: 12, ID: "pino", Changes: []CouchRevision{{Rev: "1-a"}}},
+		},
+		LastSequence: 12,
+	}}, hang: "slow"}
+	f := NewFollower().Since(10).WithEnrichConcurrency(2).WithEnrichTimeout(50 * time.Millisecond).WithClock(clock.NewFake(time.Now()))
+	f.WithDoer(d)
+	results := f.ConnectWithPackuments(t.Context())
+	defer f.Stop()
+
+	got := map[string]error{}
+	for range 2 {
+		select {
+		case r := <-results:
+			got[r.Change.ID] = r.Error
+		case <-time.After(time.Second):
+			t.Fatal("enrichment stalled")
+		}
+	}
+	if !errors.Is(got["slow"], context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded for slow, got %v", got["slow"])
+	}
+	if err, ok := got["pino"]; !ok || err != nil {
+		t.Errorf("expected pino to be enriched, got %v", err)
+	}
+}
+
+func TestTokenAfterDelivery(t *testing.T) {
+	d := &stubDoer{responses: []CouchResponse{{
+		Results:      []CouchDocumentChange{{Seq: 11, ID: "a"}, {Seq: 12, ID: "b"}},
+		LastSequence: 13,
+	}}}
+	f := NewFollower().Since(10).WithBufferSize(0).WithClock(clock.NewFake(time.Now()))
+	f.WithDoer(d)
+	if got := f.Token().Sequence; got != 10 {
+		t.Fatalf("expected the token to start at 10, got %d", got)
+	}
+	results := f.Connect(t.Context())
+
+	// the batch has been fetched, but b hasn't been delivered yet
+	receive(t, results)
+	if got := f.Token().Sequence; got > 11 {
+		t.Errorf("token moved past the undelivered change: %d", got)
+	}
+	receive(t, results)
+	f.Stop()
+	for range results {
+	}
+	if got := f.Token().Sequence; got != 13 {
+		t.Errorf("expected the token at the batch's last_seq once delivered, got %d", got)
+	}
+}
@@ -0,0 +1,45 @@
+package couch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+type requestIDKey struct{}
+
+// generate a correlation id for each poll, sent as an X-Request-Id header
+// and included in log lines and errors. gen can be RandomRequestID.
+func (f *Follower) WithRequestID(gen func() string) *Follower {
+	f.requestID = gen
+	return f
+}
+
+// returns a random 16 character hex request id
+func RandomRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// returns the request id attached to ctx, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// attaches a freshly generated request id to ctx if a generator is set
+func (f *Follower) withRequestID(ctx context.Context) context.Context {
+	if f.requestID == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, f.requestID())
+}
+
+// returns a log/error prefix for the request id in ctx
+func requestPrefix(ctx context.Context) string {
+	if id := RequestID(ctx); id != "" {
+		return "request " + id + ": "
+	}
+	return ""
+}
@@ -0,0 +1,25 @@
+// Package event defines a source-agnostic change Event emitted by both the
+// couch and rss followers, for consumers aggregating from several sources.
+package event
+
+import "time"
+
+const (
+	SourceCouch string = "couch"
+	SourceRSS   string = "rss"
+)
+
+// Event is a single package change from any follower.
+type Event struct {
+	// SourceCouch or SourceRSS
+	Source string
+	// base URL of the feed the event came from
+	Registry    string
+	PackageName string
+	// CouchDB sequence, zero for rss
+	Sequence uint64
+	// publish time for rss where available, otherwise the time the
+	// follower observed the change
+	Timestamp time.Time
+	Error     error
+}
@@ -0,0 +1,81 @@
+package event
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// returned when a ResumeToken from one source is given to a follower of
+// another.
+var ErrTokenSource = errors.New("resume token is for a different source")
+
+// ResumeToken is a position in a feed that can be persisted and handed
+// back to a follower to carry on where it left off. Use String to store it
+// and ParseResumeToken to read it back.
+type ResumeToken struct {
+	// SourceCouch or SourceRSS
+	Source string
+	// CouchDB sequence, zero for rss
+	Sequence uint64
+	// guid and pubDate of the newest rss item seen, empty for couch
+	GUID    string
+	PubDate string
+}
+
+// encodes the token as e.g. "couch:89797387". rss positions are base64
+// encoded since guids and dates contain arbitrary characters.
+func (t ResumeToken) String() string {
+	switch t.Source {
+	case SourceCouch:
+		return SourceCouch + ":" + strconv.FormatUint(t.Sequence, 10)
+	case SourceRSS:
+		enc := base64.RawURLEncoding
+		return SourceRSS + ":" + enc.EncodeToString([]byte(t.GUID)) + "." + enc.EncodeToString([]byte(t.PubDate))
+	}
+	return ""
+}
+
+// parses a token produced by ResumeToken.String.
+func ParseResumeToken(s string) (ResumeToken, error) {
+	source, pos, ok := strings.Cut(s, ":")
+	if !ok {
+		return ResumeToken{}, fmt.Errorf("parsing resume token %q: missing source", s)
+	}
+	switch source {
+	case SourceCouch:
+		seq, err := strconv.ParseUint(pos, 10, 64)
+		if err != nil {
+			return ResumeToken{}, fmt.Errorf("parsing resume token %q: %w", s, err)
+		}
+		return ResumeToken{Source: SourceCouch, Sequence: seq}, nil
+	case SourceRSS:
+		enc := base64.RawURLEncoding
+		guid, date, _ := strings.Cut(pos, ".")
+		g, err := enc.DecodeString(guid)
+		if err != nil {
+			return ResumeToken{}, fmt.Errorf("parsing resume token %q: guid: %w", s, err)
+		}
+		d, err := enc.DecodeString(date)
+		if err != nil {
+			return ResumeToken{}, fmt.Errorf("parsing resume token %q: pubDate: %w", s, err)
+		}
+		return ResumeToken{Source: SourceRSS, GUID: string(g), PubDate: string(d)}, nil
+	}
+	return ResumeToken{}, fmt.Errorf("parsing resume token %q: unknown source %q", s, source)
+}
+
+func (t ResumeToken) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *ResumeToken) UnmarshalText(b []byte) error {
+	parsed, err := ParseResumeToken(string(b))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
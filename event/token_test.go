@@ -0,0 +1,25 @@
+package event
+
+import "testing"
+
+func TestResumeTokenRoundTrip(t *testing.T) {
+	for _, want := range []ResumeToken{
+		{Source: SourceCouch, Sequence: 89797387},
+		{Source: SourceRSS, GUID: "https://www.npmjs.com/package/pino", PubDate: "Fri, 26 Dec 2025 11:07:05 GMT"},
+		{Source: SourceRSS},
+	} {
+		got, err := ParseResumeToken(want.String())
+		if err != nil {
+			t.Fatalf("%v: %v", want, err)
+		}
+		if got != want {
+			t.Errorf("round trip: got %+v, want %+v", got, want)
+		}
+	}
+	if _, err := ParseResumeToken("couch:abc"); err == nil {
+		t.Error("expected error for bad sequence")
+	}
+	if _, err := ParseResumeToken("atom:1"); err == nil {
+		t.Error("expected error for unknown source")
+	}
+}
@@ -0,0 +1,228 @@
+// Package poll holds the polling loop shared by the couch and rss followers:
+// the one-stream-at-a-time guard, tick scheduling, Result delivery and poll
+// status. Each follower wraps a Loop with its own option methods and docs.
+package poll
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kmsec-uk/npm-follower/clock"
+)
+
+// delivered when Run is called on a Loop that is already running
+var ErrAlreadyConnected = errors.New("follower is already connected")
+
+// Loop polls on an interval and delivers Results of type T. The exported
+// fields are options, set by the followers before Run.
+type Loop[T any] struct {
+	Interval        time.Duration
+	BufferSize      int
+	StartupJitter   time.Duration
+	Clock           clock.Clock
+	DriftCorrection bool
+	ResultTimeout   time.Duration
+	OnError         func(error)
+	SuppressErrors  bool
+
+	// builds the Result carrying err
+	ErrorResult func(err error) T
+	// returns r stamped with its delivery time
+	Stamp func(r T, at time.Time) T
+
+	skippedTicks atomic.Uint64
+	dropped      atomic.Uint64
+
+	connMu    sync.Mutex
+	connected bool
+	cancel    context.CancelFunc
+
+	statusMu  sync.Mutex
+	lastPoll  time.Time
+	lastErr   error
+	lastCount int
+}
+
+// starts polling and returns the Result channel, which is closed when ctx
+// is done, Stop is called or poll returns true. start, if non-nil, runs
+// before the first poll; an error from it is delivered and ends the stream.
+// poll fetches and delivers one batch, returning true to end the stream.
+func (l *Loop[T]) Run(ctx context.Context, start func(context.Context) error, poll func(ctx context.Context, out chan<- T) bool) <-chan T {
+
+	// refuse to run two loops over the same state. The error channel is
+	// buffered so this never blocks, whatever the buffer size is
+	l.connMu.Lock()
+	if l.connected {
+		l.connMu.Unlock()
+		out := make(chan T, 1)
+		out <- l.Stamp(l.ErrorResult(ErrAlreadyConnected), l.Clock.Now())
+		close(out)
+		return out
+	}
+	out := make(chan T, l.BufferSize)
+	// the follower may set Interval again before its next Run
+	interval := l.Interval
+	ctx, cancel := context.WithCancel(ctx)
+	l.connected = true
+	l.cancel = cancel
+	l.connMu.Unlock()
+	// allow Run again once this stream has ended
+	disconnect := func() {
+		cancel()
+		l.connMu.Lock()
+		l.connected = false
+		l.connMu.Unlock()
+	}
+	if start != nil {
+		if err := start(ctx); err != nil {
+			go func() {
+				defer disconnect()
+				out <- l.Stamp(l.ErrorResult(err), l.Clock.Now())
+				close(out)
+			}()
+			return out
+		}
+	}
+
+	go func() {
+		defer close(out)
+		defer disconnect()
+		ticker := l.Clock.NewTicker(interval)
+		defer ticker.Stop()
+
+		// set when polling should stop and the channel close
+		finished := false
+
+		// desynchronize fleets of followers started together
+		if l.StartupJitter > 0 {
+			if clock.Sleep(ctx, l.Clock, rand.N(l.StartupJitter)) != nil {
+				return
+			}
+		}
+		// fetches run one at a time on this goroutine, so they never
+		// overlap. Returns the fetch duration, for DriftCorrection and
+		// SkippedTicks
+		timedFetch := func() time.Duration {
+			start := l.Clock.Now()
+			finished = poll(ctx, out)
+			return l.Clock.Now().Sub(start)
+		}
+		took := timedFetch()
+		for !finished {
+			if l.DriftCorrection {
+				if clock.Sleep(ctx, l.Clock, interval-took) != nil {
+					return
+				}
+				took = timedFetch()
+				continue
+			}
+			// ticks that fall due while a slow fetch is running are dropped
+			// by the ticker, account for them here
+			if missed := took / interval; missed > 0 {
+				l.skippedTicks.Add(uint64(missed))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				took = timedFetch()
+			}
+		}
+	}()
+	return out
+}
+
+// cancels the running stream, if any.
+func (l *Loop[T]) Stop() {
+	l.connMu.Lock()
+	defer l.connMu.Unlock()
+	if l.cancel != nil {
+		l.cancel()
+	}
+}
+
+// reports a poll error to OnError and, unless SuppressErrors is set,
+// delivers it as a Result. Returns false if ctx is done first.
+func (l *Loop[T]) Fail(ctx context.Context, out chan<- T, err error) bool {
+	if l.OnError != nil {
+		l.OnError(err)
+	}
+	if l.SuppressErrors {
+		return ctx.Err() == nil
+	}
+	return l.Send(ctx, out, l.ErrorResult(err))
+}
+
+// stamps r and delivers it on out, returning false if ctx is done first.
+// With a ResultTimeout, r is dropped and counted in Dropped if out stays
+// blocked for the timeout.
+func (l *Loop[T]) Send(ctx context.Context, out chan<- T, r T) bool {
+	r = l.Stamp(r, l.Clock.Now())
+	if l.ResultTimeout <= 0 {
+		select {
+		case out <- r:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	select {
+	case out <- r:
+		return true
+	default:
+	}
+	select {
+	case out <- r:
+	case <-ctx.Done():
+		return false
+	case <-l.Clock.After(l.ResultTimeout):
+		l.dropped.Add(1)
+	}
+	return true
+}
+
+// records the outcome of a poll for LastPollTime, LastError and LastPollCount
+func (l *Loop[T]) RecordPoll(count int, err error) {
+	l.statusMu.Lock()
+	defer l.statusMu.Unlock()
+	l.lastErr = err
+	if err == nil {
+		l.lastPoll = l.Clock.Now()
+		l.lastCount = count
+	}
+}
+
+// returns the time of the last successful poll
+func (l *Loop[T]) LastPollTime() time.Time {
+	l.statusMu.Lock()
+	defer l.statusMu.Unlock()
+	return l.lastPoll
+}
+
+// returns the error from the most recent poll
+func (l *Loop[T]) LastError() error {
+	l.statusMu.Lock()
+	defer l.statusMu.Unlock()
+	return l.lastErr
+}
+
+// returns the count recorded by the last successful poll
+func (l *Loop[T]) LastPollCount() int {
+	l.statusMu.Lock()
+	defer l.statusMu.Unlock()
+	return l.lastCount
+}
+
+// returns the number of ticks skipped by slow fetches
+func (l *Loop[T]) SkippedTicks() uint64 {
+	return l.skippedTicks.Load()
+}
+
+// returns the number of Results dropped by ResultTimeout
+func (l *Loop[T]) Dropped() uint64 {
+	return l.dropped.Load()
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Advisory is a security advisory from the npm bulk advisory endpoint.
+type Advisory struct {
+	ID                 int    `json:"id"`
+	Title              string `json:"title"`
+	Severity           string `json:"severity"`
+	VulnerableVersions string `json:"vulnerable_versions"`
+	URL                string `json:"url"`
+}
+
+// returns the security advisories affecting a given version of a package.
+// Equivalent to POSTing {name: [version]} to
+// https://registry.npmjs.com/-/npm/v1/security/advisories/bulk
+func (c *RegistryClient) GetAdvisories(ctx context.Context, name, version string) ([]Advisory, error) {
+	payload, err := json.Marshal(map[string][]string{name: {version}})
+	if err != nil {
+		return nil, fmt.Errorf("advisories: `%s@%s`: encoding request: %w", name, version, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://registry.npmjs.com/-/npm/v1/security/advisories/bulk", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("advisories: `%s@%s`: creating request: %w", name, version, err)
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("user-agent", c.UserAgent)
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("advisories: `%s@%s`: performing request: %w", name, version, err)
+	}
+	defer drainAndClose(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("advisories: `%s@%s`: unexpected status code %d from %s", name, version, res.StatusCode, res.Request.URL)
+	}
+	var advisories map[string][]Advisory
+	r, debug := c.DebugReader(c.LimitBody(res.Body))
+	err = debug(json.NewDecoder(r).Decode(&advisories))
+	if err != nil {
+		return nil, fmt.Errorf("advisories: `%s@%s`: decoding response: %w", name, version, err)
+	}
+	return advisories[name], nil
+}
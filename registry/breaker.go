@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// returned without making a request while the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// after failureThreshold consecutive failed requests (transport errors or
+// 5xx responses), fail every request fast with ErrCircuitOpen for cooldown.
+// Once the cooldown has elapsed a single probe request is let through; if
+// it succeeds the breaker closes, otherwise it stays open for another
+// cooldown. Requests cancelled by their own context don't count as
+// failures. A failureThreshold <= 0 disables the breaker.
+func (c *RegistryClient) WithCircuitBreaker(failureThreshold int, cooldown time.Duration) *RegistryClient {
+	if failureThreshold <= 0 {
+		c.breaker = nil
+		return c
+	}
+	c.breaker = &circuitBreaker{threshold: failureThreshold, cooldown: cooldown, now: time.Now}
+	return c
+}
+
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// reports whether a request may be made, marking it as the probe if the
+// breaker is half-open
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || b.now().Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// records the outcome of a request made through Do
+func (b *circuitBreaker) record(req *http.Request, res *http.Response, err error) {
+	if req.Context().Err() != nil {
+		b.mu.Lock()
+		b.probing = false
+		b.mu.Unlock()
+		return
+	}
+	failed := err != nil || res.StatusCode >= http.StatusInternalServerError
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+}
@@ -0,0 +1,113 @@
+package registry
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cache decoded packuments fetched by GetPackument for ttl, keeping at most
+// maxEntries packuments and evicting the least recently used. Useful when
+// the same package changes several times in quick succession. Cached
+// packuments are shared between callers and must not be modified.
+//
+// A cached packument may be older than the registry's, so check its Rev
+// against the change being processed and use EvictPackument to refetch.
+func (c *RegistryClient) WithPackumentCache(ttl time.Duration, maxEntries int) *RegistryClient {
+	c.cache = &packumentCache{
+		ttl:     ttl,
+		size:    maxEntries,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, maxEntries),
+	}
+	return c
+}
+
+// removes a package from the packument cache, so the next GetPackument
+// fetches it from the registry. Returns true if it was cached.
+func (c *RegistryClient) EvictPackument(id string) bool {
+	if c.cache == nil {
+		return false
+	}
+	return c.cache.evict(id)
+}
+
+// returns the number of GetPackument calls served from and missing the
+// packument cache.
+func (c *RegistryClient) PackumentCacheStats() (hits, misses uint64) {
+	if c.cache == nil {
+		return 0, 0
+	}
+	return c.cache.hits.Load(), c.cache.misses.Load()
+}
+
+// packumentCache is a bounded LRU of packuments with a per-entry expiry.
+type packumentCache struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	id        string
+	packument *Packument
+	expires   time.Time
+}
+
+func (pc *packumentCache) get(id string) (*Packument, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	el, ok := pc.entries[id]
+	if !ok {
+		pc.misses.Add(1)
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !pc.now().Before(entry.expires) {
+		pc.order.Remove(el)
+		delete(pc.entries, id)
+		pc.misses.Add(1)
+		return nil, false
+	}
+	pc.order.MoveToFront(el)
+	pc.hits.Add(1)
+	return entry.packument, true
+}
+
+func (pc *packumentCache) put(id string, p *Packument) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	entry := &cacheEntry{id: id, packument: p, expires: pc.now().Add(pc.ttl)}
+	if el, ok := pc.entries[id]; ok {
+		el.Value = entry
+		pc.order.MoveToFront(el)
+		return
+	}
+	pc.entries[id] = pc.order.PushFront(entry)
+	if pc.order.Len() > pc.size {
+		oldest := pc.order.Back()
+		pc.order.Remove(oldest)
+		delete(pc.entries, oldest.Value.(*cacheEntry).id)
+	}
+}
+
+func (pc *packumentCache) evict(id string) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	el, ok := pc.entries[id]
+	if !ok {
+		return false
+	}
+	pc.order.Remove(el)
+	delete(pc.entries, id)
+	return true
+}
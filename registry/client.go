@@ -1,22 +1,50 @@
 package registry
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"time"
 )
 
-const defaultUserAgent string = "npm-replicate-client (go)"
+const (
+	defaultUserAgent   string = "npm-replicate-client (go)"
+	defaultMaxBodySize int64  = 100 << 20 // 100MB
+)
+
+var ErrBodyTooLarge = errors.New("response body exceeds maximum size")
+
+// Doer performs HTTP requests. *http.Client satisfies it; tests can supply
+// a stub returning canned responses.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
 
 // RegistryClient handles the interaction with the npm registry api.
 type RegistryClient struct {
 	Client    *http.Client
 	UserAgent string
+	// if set, requests are sent through Doer instead of Client
+	Doer        Doer
+	maxBodySize int64
+	decodeDebug bool
+
+	packumentDecoder func(io.Reader) (*Packument, error)
+	breaker          *circuitBreaker
+	cache            *packumentCache
+
+	largeThreshold   int64
+	onLargePackument func(id string, size int64)
 }
 
 func NewClient() *RegistryClient {
 	return &RegistryClient{
-		Client:    &http.Client{Timeout: 5 * time.Second},
-		UserAgent: defaultUserAgent,
+		Client:      &http.Client{Timeout: 5 * time.Second},
+		UserAgent:   defaultUserAgent,
+		maxBodySize: defaultMaxBodySize,
 	}
 }
 
@@ -29,3 +57,164 @@ func (c *RegistryClient) WithUserAgent(ua string) *RegistryClient {
 	c.UserAgent = ua
 	return c
 }
+
+// configure granular timeouts on the client's transport: connection dial,
+// TLS handshake and waiting for response headers. The overall
+// Client.Timeout set by WithHTTPTimeout still applies and includes reading
+// the body; set it to 0 to give slow-but-healthy large downloads an
+// unbounded body-read window.
+func (c *RegistryClient) WithTransportTimeouts(dial, tlsHandshake, responseHeader time.Duration) *RegistryClient {
+	t := c.transport()
+	t.DialContext = (&net.Dialer{Timeout: dial, KeepAlive: 30 * time.Second}).DialContext
+	t.TLSHandshakeTimeout = tlsHandshake
+	t.ResponseHeaderTimeout = responseHeader
+	return c
+}
+
+// cap the number of connections per host, both in use and idle, e.g. to
+// size the connection budget for concurrent packument fetches. This limits
+// concurrent connections only, not request rate: requests beyond the cap
+// wait for a free connection. Default is Go's (unlimited, with 2 idle).
+func (c *RegistryClient) WithMaxConnsPerHost(n int) *RegistryClient {
+	t := c.transport()
+	t.MaxConnsPerHost = n
+	t.MaxIdleConnsPerHost = n
+	return c
+}
+
+// WARNING: disables TLS certificate verification, leaving every request
+// open to interception. For testing against a local mirror with a
+// self-signed certificate only, e.g. combined with the couch Follower's
+// WithReplicateURL. Never use this against the public registry.
+func (c *RegistryClient) WithInsecureSkipVerify() *RegistryClient {
+	t := c.transport()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.InsecureSkipVerify = true
+	return c
+}
+
+// returns the client's *http.Transport, installing a clone of
+// http.DefaultTransport first if the client does not have its own.
+func (c *RegistryClient) transport() *http.Transport {
+	if t, ok := c.Client.Transport.(*http.Transport); ok {
+		return t
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	c.Client.Transport = t
+	return t
+}
+
+// send requests through a custom Doer rather than the embedded http.Client.
+func (c *RegistryClient) WithDoer(d Doer) *RegistryClient {
+	c.Doer = d
+	return c
+}
+
+// sends the request with the configured Doer, defaulting to Client.
+func (c *RegistryClient) Do(req *http.Request) (*http.Response, error) {
+	if c.breaker == nil {
+		return c.do(req)
+	}
+	if !c.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	res, err := c.do(req)
+	c.breaker.record(req, res, err)
+	return res, err
+}
+
+func (c *RegistryClient) do(req *http.Request) (*http.Response, error) {
+	if c.Doer != nil {
+		return c.Doer.Do(req)
+	}
+	return c.Client.Do(req)
+}
+
+// cap the size of response bodies read by the client and the followers.
+// Reads beyond n bytes fail with ErrBodyTooLarge. Default is 100MB; n <= 0
+// disables the limit.
+func (c *RegistryClient) WithMaxBodySize(n int64) *RegistryClient {
+	c.maxBodySize = n
+	return c
+}
+
+// wraps a response body so reading more than the configured maximum body
+// size returns ErrBodyTooLarge.
+func (c *RegistryClient) LimitBody(body io.ReadCloser) io.ReadCloser {
+	if c.maxBodySize <= 0 {
+		return body
+	}
+	return &limitedBody{ReadCloser: body, remaining: c.maxBodySize}
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	// read one byte past the limit to detect an oversized body
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = -1
+		return n, ErrBodyTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// drains (up to a bound) and closes a response body so the underlying
+// keep-alive connection can be reused.
+func drainAndClose(body io.ReadCloser) {
+	_, _ = io.CopyN(io.Discard, body, 64<<10)
+	_ = body.Close()
+}
+
+// on decode failures, include a snippet of the most recently read body
+// bytes in the returned error to help diagnose unexpected schemas.
+func (c *RegistryClient) WithDecodeDebug() *RegistryClient {
+	c.decodeDebug = true
+	return c
+}
+
+// number of trailing body bytes kept for decode debugging
+const debugSnippetSize = 512
+
+// returns a reader to decode from and a function to wrap decode errors.
+// Unless WithDecodeDebug is set, r is returned as-is and errors are not
+// modified. Otherwise the last bytes read from r are appended to errors.
+func (c *RegistryClient) DebugReader(r io.Reader) (io.Reader, func(error) error) {
+	if !c.decodeDebug {
+		return r, func(err error) error { return err }
+	}
+	tail := &tailBuffer{}
+	wrap := func(err error) error {
+		if err == nil {
+			return nil
+		}
+		return fmt.Errorf("%w (body near error: %q)", err, tail.buf)
+	}
+	return io.TeeReader(r, tail), wrap
+}
+
+// keeps the last debugSnippetSize bytes written to it
+type tailBuffer struct {
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - debugSnippetSize; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
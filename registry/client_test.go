@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitBody(t *testing.T) {
+	c := NewClient().WithMaxBodySize(5)
+	b, err := io.ReadAll(c.LimitBody(io.NopCloser(strings.NewReader("12345"))))
+	if err != nil || string(b) != "12345" {
+		t.Errorf("body at limit: got %q, %v", b, err)
+	}
+	b, err = io.ReadAll(c.LimitBody(io.NopCloser(strings.NewReader("123456"))))
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+	if string(b) != "12345" {
+		t.Errorf("expected truncated body, got %q", b)
+	}
+}
+
+func TestWithTransportTimeouts(t *testing.T) {
+	c := NewClient().WithTransportTimeouts(time.Second, 2*time.Second, 3*time.Second)
+	tr, ok := c.Client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected *http.Transport, got %T", c.Client.Transport)
+	}
+	if tr.TLSHandshakeTimeout != 2*time.Second || tr.ResponseHeaderTimeout != 3*time.Second {
+		t.Errorf("unexpected timeouts: tls %v, header %v", tr.TLSHandshakeTimeout, tr.ResponseHeaderTimeout)
+	}
+	if tr == http.DefaultTransport {
+		t.Error("default transport was modified")
+	}
+}
+
+func TestWithMaxConnsPerHost(t *testing.T) {
+	c := NewClient().WithMaxConnsPerHost(8)
+	tr := c.Client.Transport.(*http.Transport)
+	if tr.MaxConnsPerHost != 8 || tr.MaxIdleConnsPerHost != 8 {
+		t.Errorf("unexpected limits: %d, %d", tr.MaxConnsPerHost, tr.MaxIdleConnsPerHost)
+	}
+}
+
+func TestWithInsecureSkipVerify(t *testing.T) {
+	c := NewClient().WithMaxConnsPerHost(8).WithInsecureSkipVerify()
+	tr := c.Client.Transport.(*http.Transport)
+	if tr.TLSClientConfig == nil || !tr.TLSClientConfig.InsecureSkipVerify || tr.MaxConnsPerHost != 8 {
+		t.Errorf("unexpected transport config: %+v", tr)
+	}
+	if d := http.DefaultTransport.(*http.Transport); d.TLSClientConfig != nil && d.TLSClientConfig.InsecureSkipVerify {
+		t.Error("default transport was modified")
+	}
+}
+
+func TestDecodeDebug(t *testing.T) {
+	c := NewClient().WithDoer(&stubDoer{status: http.StatusOK, body: `{"name": "pino", "versions": [}`})
+	_, err := c.GetPackument(context.Background(), "pino")
+	if err == nil || strings.Contains(err.Error(), "versions") {
+		t.Fatalf("expected plain decode error without body, got %v", err)
+	}
+	c.WithDecodeDebug()
+	_, err = c.GetPackument(context.Background(), "pino")
+	if err == nil || !strings.Contains(err.Error(), `\"versions\": [}`) {
+		t.Errorf("expected body snippet in error, got %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	c := NewClient().WithCircuitBreaker(2, time.Minute)
+	c.breaker.now = func() time.Time { return now }
+	c.WithDoer(&stubDoer{status: http.StatusServiceUnavailable})
+	ctx := t.Context()
+	for range 2 {
+		if _, err := c.GetPackument(ctx, "pino"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected registry failure, got %v", err)
+		}
+	}
+	if _, err := c.GetPackument(ctx, "pino"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	// probe after cooldown succeeds and closes the breaker
+	now = now.Add(time.Minute)
+	c.WithDoer(&stubDoer{status: http.StatusOK, body: `{"name": "pino"}`})
+	for range 2 {
+		if _, err := c.GetPackument(ctx, "pino"); err != nil {
+			t.Fatalf("expected breaker to close, got %v", err)
+		}
+	}
+	// a zero threshold disables the breaker
+	c.WithCircuitBreaker(0, time.Minute).WithDoer(&stubDoer{status: http.StatusServiceUnavailable})
+	for range 3 {
+		if _, err := c.GetPackument(ctx, "pino"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected registry failure with the breaker disabled, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"cmp"
+	"slices"
+)
+
+// a change in the version a dist-tag points to. From is empty if the tag
+// was added, To is empty if the tag was removed.
+type DistTagChange struct {
+	Tag  string
+	From string
+	To   string
+}
+
+// the differences between two snapshots of the same Packument.
+type PackumentDiff struct {
+	AddedVersions      []string
+	RemovedVersions    []string
+	DistTagChanges     []DistTagChange
+	AddedMaintainers   []Contact
+	RemovedMaintainers []Contact
+	DescriptionChanged bool
+	OldDescription     string
+	NewDescription     string
+}
+
+// returns true if the diff contains no changes
+func (d *PackumentDiff) Empty() bool {
+	return len(d.AddedVersions) == 0 &&
+		len(d.RemovedVersions) == 0 &&
+		len(d.DistTagChanges) == 0 &&
+		len(d.AddedMaintainers) == 0 &&
+		len(d.RemovedMaintainers) == 0 &&
+		!d.DescriptionChanged
+}
+
+// computes what changed between an old and a new snapshot of a packument.
+// A nil old packument is treated as empty, so every version, dist-tag and
+// maintainer of new is reported as added. Slices are sorted for stable output.
+func DiffPackuments(old, new *Packument) *PackumentDiff {
+	if old == nil {
+		old = &Packument{}
+	}
+	if new == nil {
+		new = &Packument{}
+	}
+	d := &PackumentDiff{}
+
+	// versions
+	for v := range new.Versions {
+		if _, ok := old.Versions[v]; !ok {
+			d.AddedVersions = append(d.AddedVersions, v)
+		}
+	}
+	for v := range old.Versions {
+		if _, ok := new.Versions[v]; !ok {
+			d.RemovedVersions = append(d.RemovedVersions, v)
+		}
+	}
+	slices.Sort(d.AddedVersions)
+	slices.Sort(d.RemovedVersions)
+
+	// dist-tags
+	for tag, to := range new.DistTags {
+		if from := old.DistTags[tag]; from != to {
+			d.DistTagChanges = append(d.DistTagChanges, DistTagChange{Tag: tag, From: from, To: to})
+		}
+	}
+	for tag, from := range old.DistTags {
+		if _, ok := new.DistTags[tag]; !ok {
+			d.DistTagChanges = append(d.DistTagChanges, DistTagChange{Tag: tag, From: from})
+		}
+	}
+	slices.SortFunc(d.DistTagChanges, func(a, b DistTagChange) int {
+		return cmp.Compare(a.Tag, b.Tag)
+	})
+
+	// maintainers, identified by npm username
+	hasMaintainer := func(list []Contact, name string) bool {
+		return slices.ContainsFunc(list, func(c Contact) bool { return c.Name == name })
+	}
+	for _, m := range new.Maintainers {
+		if !hasMaintainer(old.Maintainers, m.Name) {
+			d.AddedMaintainers = append(d.AddedMaintainers, m)
+		}
+	}
+	for _, m := range old.Maintainers {
+		if !hasMaintainer(new.Maintainers, m.Name) {
+			d.RemovedMaintainers = append(d.RemovedMaintainers, m)
+		}
+	}
+
+	// description
+	if old.Description != new.Description {
+		d.DescriptionChanged = true
+		d.OldDescription = old.Description
+		d.NewDescription = new.Description
+	}
+	return d
+}
+
+// returns the versions present in both snapshots whose dist integrity or
+// shasum changed, sorted. npm forbids republishing a version, so a hit is
+// a strong signal the tarball was tampered with (or a mirror is serving
+// something it shouldn't). Fields missing from either snapshot are not
+// compared.
+func DetectRepublish(old, new *Packument) []string {
+	if old == nil || new == nil {
+		return nil
+	}
+	changed := func(a, b string) bool {
+		return a != "" && b != "" && a != b
+	}
+	var versions []string
+	for v, pv := range new.Versions {
+		prev, ok := old.Versions[v]
+		if !ok {
+			continue
+		}
+		if changed(prev.Dist.Integrity, pv.Dist.Integrity) || changed(prev.Dist.Shasum, pv.Dist.Shasum) {
+			versions = append(versions, v)
+		}
+	}
+	slices.Sort(versions)
+	return versions
+}
@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiffPackumentsNewVersion(t *testing.T) {
+	old := &Packument{
+		Name:     "pino",
+		Versions: map[string]PackageVersion{"1.0.0": {Version: "1.0.0"}},
+		DistTags: map[string]string{"latest": "1.0.0"},
+	}
+	new := &Packument{
+		Name: "pino",
+		Versions: map[string]PackageVersion{
+			"1.0.0": {Version: "1.0.0"},
+			"1.0.1": {Version: "1.0.1"},
+		},
+		DistTags: map[string]string{"latest": "1.0.1"},
+	}
+	d := DiffPackuments(old, new)
+	if !slices.Equal(d.AddedVersions, []string{"1.0.1"}) {
+		t.Errorf("added versions: got %v", d.AddedVersions)
+	}
+	if len(d.RemovedVersions) != 0 {
+		t.Errorf("removed versions: got %v", d.RemovedVersions)
+	}
+	want := DistTagChange{Tag: "latest", From: "1.0.0", To: "1.0.1"}
+	if len(d.DistTagChanges) != 1 || d.DistTagChanges[0] != want {
+		t.Errorf("dist-tag changes: got %v", d.DistTagChanges)
+	}
+	if d.Empty() {
+		t.Error("diff should not be empty")
+	}
+}
+
+func TestDiffPackumentsDistTagPromotion(t *testing.T) {
+	versions := map[string]PackageVersion{
+		"1.0.0":        {Version: "1.0.0"},
+		"2.0.0-beta.1": {Version: "2.0.0-beta.1"},
+	}
+	old := &Packument{
+		Versions:    versions,
+		DistTags:    map[string]string{"latest": "1.0.0", "next": "2.0.0-beta.1"},
+		Maintainers: []Contact{{Name: "alice"}},
+	}
+	new := &Packument{
+		Versions:    versions,
+		DistTags:    map[string]string{"latest": "2.0.0-beta.1"},
+		Maintainers: []Contact{{Name: "alice"}, {Name: "bob"}},
+	}
+	d := DiffPackuments(old, new)
+	if len(d.AddedVersions) != 0 || len(d.RemovedVersions) != 0 {
+		t.Errorf("expected no version changes, got +%v -%v", d.AddedVersions, d.RemovedVersions)
+	}
+	want := []DistTagChange{
+		{Tag: "latest", From: "1.0.0", To: "2.0.0-beta.1"},
+		{Tag: "next", From: "2.0.0-beta.1"},
+	}
+	if !slices.Equal(d.DistTagChanges, want) {
+		t.Errorf("dist-tag changes: got %v, want %v", d.DistTagChanges, want)
+	}
+	if len(d.AddedMaintainers) != 1 || d.AddedMaintainers[0].Name != "bob" {
+		t.Errorf("added maintainers: got %v", d.AddedMaintainers)
+	}
+	if d.DescriptionChanged {
+		t.Error("description should be unchanged")
+	}
+}
+
+func TestDetectRepublish(t *testing.T) {
+	old := &Packument{Versions: map[string]PackageVersion{
+		"1.0.0": {Dist: Dist{Integrity: "sha512-aaa", Shasum: "111"}},
+		"1.0.1": {Dist: Dist{Integrity: "sha512-bbb", Shasum: "222"}},
+	}}
+	new := &Packument{Versions: map[string]PackageVersion{
+		"1.0.0": {Dist: Dist{Integrity: "sha512-aaa", Shasum: "111"}},
+		"1.0.1": {Dist: Dist{Integrity: "sha512-evil", Shasum: "222"}},
+		"1.0.2": {Dist: Dist{Integrity: "sha512-ccc", Shasum: "333"}},
+	}}
+	if got := DetectRepublish(old, new); !slices.Equal(got, []string{"1.0.1"}) {
+		t.Errorf("got %v, want [1.0.1]", got)
+	}
+}
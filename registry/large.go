@@ -0,0 +1,48 @@
+package registry
+
+import "io"
+
+// call fn with the package id and size whenever a fetched packument is
+// larger than threshold bytes, so pathologically large packages can be
+// spotted and special-cased (e.g. with StreamVersions). The size comes
+// from Content-Length and is reported before decoding; if the registry
+// doesn't send one, fn is called once the bytes read pass the threshold,
+// with the count read so far. A nil fn turns the check off.
+func (c *RegistryClient) WithLargePackumentWarn(threshold int64, fn func(id string, size int64)) *RegistryClient {
+	c.largeThreshold = threshold
+	c.onLargePackument = fn
+	return c
+}
+
+// applies WithLargePackumentWarn to a packument response body
+func (c *RegistryClient) warnLarge(id string, contentLength int64, body io.ReadCloser) io.ReadCloser {
+	if c.onLargePackument == nil {
+		return body
+	}
+	if contentLength > c.largeThreshold {
+		c.onLargePackument(id, contentLength)
+		return body
+	}
+	if contentLength >= 0 {
+		return body
+	}
+	return &sizeWatcher{ReadCloser: body, threshold: c.largeThreshold, fn: func(n int64) { c.onLargePackument(id, n) }}
+}
+
+// calls fn once when more than threshold bytes have been read
+type sizeWatcher struct {
+	io.ReadCloser
+	threshold int64
+	read      int64
+	fn        func(n int64)
+}
+
+func (w *sizeWatcher) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	before := w.read
+	w.read += int64(n)
+	if before <= w.threshold && w.read > w.threshold {
+		w.fn(w.read)
+	}
+	return n, err
+}
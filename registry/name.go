@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// maximum length of a package name, including any scope
+const maxPackageNameLength = 214
+
+var ErrInvalidPackageName = errors.New("invalid package name")
+
+// names npm refuses regardless of the rules below
+var blockedPackageNames = []string{"node_modules", "favicon.ico"}
+
+// node core module names, which npm refuses for new unscoped packages.
+// Some (e.g. events, buffer) were published before the rule existed.
+var coreModuleNames = []string{
+	"assert", "async_hooks", "buffer", "child_process", "cluster", "console",
+	"constants", "crypto", "dgram", "diagnostics_channel", "dns", "domain",
+	"events", "fs", "http", "http2", "https", "inspector", "module", "net",
+	"os", "path", "perf_hooks", "process", "punycode", "querystring",
+	"readline", "repl", "stream", "string_decoder", "sys", "timers", "tls",
+	"trace_events", "tty", "url", "util", "v8", "vm", "wasi",
+	"worker_threads", "zlib",
+}
+
+// splits a package name into its scope (without the leading @) and bare
+// name, e.g. "@types/node" -> "types", "node". The scope is empty for
+// unscoped packages.
+func SplitPackageName(name string) (scope, bare string) {
+	if !strings.HasPrefix(name, "@") {
+		return "", name
+	}
+	scope, bare, ok := strings.Cut(name[1:], "/")
+	if !ok {
+		return "", name
+	}
+	return scope, bare
+}
+
+// checks a package name against npm's rules for new packages, as in the
+// validate-npm-package-name package, returning an error wrapping
+// ErrInvalidPackageName that describes the first violation. Some legacy
+// packages predate these rules (e.g. uppercase names like JSONStream) and
+// exist on the registry despite failing validation.
+func ValidatePackageName(name string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w %q: %s", ErrInvalidPackageName, name, reason)
+	}
+	if name == "" {
+		return invalid("name is empty")
+	}
+	// these apply to the whole name, so a scoped bare name like @scope/_foo
+	// is fine
+	if strings.HasPrefix(name, ".") {
+		return invalid("name cannot start with a period")
+	}
+	if strings.HasPrefix(name, "_") {
+		return invalid("name cannot start with an underscore")
+	}
+	if strings.TrimSpace(name) != name {
+		return invalid("name has leading or trailing spaces")
+	}
+	if len(name) > maxPackageNameLength {
+		return invalid(fmt.Sprintf("name is longer than %d characters", maxPackageNameLength))
+	}
+	for _, blocked := range blockedPackageNames {
+		if strings.EqualFold(name, blocked) {
+			return invalid("name is blocked")
+		}
+	}
+	for _, core := range coreModuleNames {
+		if strings.EqualFold(name, core) {
+			return invalid("name is a node core module name")
+		}
+	}
+	if strings.ToLower(name) != name {
+		return invalid("name cannot contain uppercase letters")
+	}
+	parts := []string{name}
+	if strings.HasPrefix(name, "@") {
+		scope, bare, ok := strings.Cut(name[1:], "/")
+		if !ok || scope == "" || bare == "" || strings.Contains(bare, "/") {
+			return invalid("scoped name must be of the form @scope/name")
+		}
+		parts = []string{scope, bare}
+	}
+	// npm only checks the bare name for these, even though they're URL-safe
+	if i := strings.IndexAny(parts[len(parts)-1], "~'!()*"); i >= 0 {
+		return invalid(fmt.Sprintf("name cannot contain special character %q", parts[len(parts)-1][i]))
+	}
+	for _, part := range parts {
+		for _, r := range part {
+			if !urlSafe(r) {
+				return invalid(fmt.Sprintf("name cannot contain URL-unsafe character %q", r))
+			}
+		}
+	}
+	return nil
+}
+
+// reports whether r is left alone by javascript's encodeURIComponent
+func urlSafe(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("-_.!~*'()", r)
+}
+
+// returns the npmjs.com page for a package. Scoped names are left
+// unescaped, e.g. https://www.npmjs.com/package/@types/node
+func PackageURL(name string) string {
+	scope, bare := SplitPackageName(name)
+	if scope == "" {
+		return "https://www.npmjs.com/package/" + url.PathEscape(bare)
+	}
+	return "https://www.npmjs.com/package/@" + url.PathEscape(scope) + "/" + url.PathEscape(bare)
+}
+
+// returns the npmjs.com page for a specific version of a package.
+func VersionURL(name, version string) string {
+	return PackageURL(name) + "/v/" + url.PathEscape(version)
+}
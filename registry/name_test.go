@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidatePackageName(t *testing.T) {
+	testCases := []struct {
+		name  string
+		valid bool
+	}{
+		{"pino", true},
+		{"@types/node", true},
+		{"lodash.merge", true},
+		{"a-b_c", true},
+		{"", false},
+		{strings.Repeat("a", 215), false},
+		{" pino", false},
+		{".pino", false},
+		{"_pino", false},
+		{"@types/_node", true},
+		{"@_scope/pino", true},
+		{"@babel/core", true},
+		{"@vue/compiler-sfc", true},
+		{"@types/node!", false},
+		{"fs", false},
+		{"HTTP", false},
+		{"@types/fs", true},
+		{"JSONStream", false},
+		{"@types", false},
+		{"@/node", false},
+		{"@types/node/extra", false},
+		{"pi no", false},
+		{"pino!", false},
+		{"pino/extra", false},
+		{"node_modules", false},
+	}
+	for _, tc := range testCases {
+		err := ValidatePackageName(tc.name)
+		if tc.valid && err != nil {
+			t.Errorf("ValidatePackageName(%q) = %v, want nil", tc.name, err)
+		}
+		if !tc.valid && !errors.Is(err, ErrInvalidPackageName) {
+			t.Errorf("ValidatePackageName(%q) = %v, want ErrInvalidPackageName", tc.name, err)
+		}
+	}
+}
+
+func TestSplitPackageName(t *testing.T) {
+	testCases := []struct {
+		name, scope, bare string
+	}{
+		{"pino", "", "pino"},
+		{"@types/node", "types", "node"},
+		{"@types", "", "@types"},
+	}
+	for _, tc := range testCases {
+		scope, bare := SplitPackageName(tc.name)
+		if scope != tc.scope || bare != tc.bare {
+			t.Errorf("SplitPackageName(%q) = %q, %q, want %q, %q", tc.name, scope, bare, tc.scope, tc.bare)
+		}
+	}
+}
+
+func TestPackageURL(t *testing.T) {
+	testCases := []struct {
+		name, version, pkgURL, versionURL string
+	}{
+		{"pino", "9.0.0", "https://www.npmjs.com/package/pino", "https://www.npmjs.com/package/pino/v/9.0.0"},
+		{"@types/node", "22.0.0-beta.1", "https://www.npmjs.com/package/@types/node", "https://www.npmjs.com/package/@types/node/v/22.0.0-beta.1"},
+	}
+	for _, tc := range testCases {
+		if got := PackageURL(tc.name); got != tc.pkgURL {
+			t.Errorf("PackageURL(%q) = %s, want %s", tc.name, got, tc.pkgURL)
+		}
+		if got := VersionURL(tc.name, tc.version); got != tc.versionURL {
+			t.Errorf("VersionURL(%q, %q) = %s, want %s", tc.name, tc.version, got, tc.versionURL)
+		}
+	}
+}
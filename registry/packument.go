@@ -8,6 +8,9 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
+	"time"
 )
 
 type Contact struct {
@@ -34,10 +37,30 @@ func (c *Contact) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &s); err != nil {
 		return err
 	}
-	*c = Contact{Name: s}
+	*c = parseContact(s)
 	return nil
 }
 
+// parses the npm person shorthand "Name <email> (url)", where the email
+// and url are both optional
+func parseContact(s string) Contact {
+	var c Contact
+	if i := strings.Index(s, "("); i >= 0 {
+		if j := strings.Index(s[i:], ")"); j >= 0 {
+			c.URL = strings.TrimSpace(s[i+1 : i+j])
+			s = s[:i] + s[i+j+1:]
+		}
+	}
+	if i := strings.Index(s, "<"); i >= 0 {
+		if j := strings.Index(s[i:], ">"); j >= 0 {
+			c.Email = strings.TrimSpace(s[i+1 : i+j])
+			s = s[:i] + s[i+j+1:]
+		}
+	}
+	c.Name = strings.TrimSpace(s)
+	return c
+}
+
 type Repository struct {
 	URL       string `json:"url"`
 	Type      string `json:"type,omitempty"`
@@ -48,11 +71,12 @@ type Repository struct {
 func (r *Repository) UnmarshalJSON(data []byte) error {
 	// Try to unmarshal as object
 	var obj struct {
-		Type string `json:"type"`
-		URL  string `json:"url"`
+		Type      string `json:"type"`
+		URL       string `json:"url"`
+		Directory string `json:"directory"`
 	}
 	if err := json.Unmarshal(data, &obj); err == nil {
-		*r = Repository{Type: obj.Type, URL: obj.URL}
+		*r = Repository{Type: obj.Type, URL: obj.URL, Directory: obj.Directory}
 		return nil
 	}
 
@@ -64,6 +88,28 @@ func (r *Repository) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// SPDX license id. Legacy packages use an object with a type property.
+// Other shapes (e.g. arrays in the style of the old `licenses` field)
+// decode to an empty License rather than failing the packument.
+type License string
+
+func (l *License) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = License(s)
+		return nil
+	}
+	var obj struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		*l = ""
+		return nil
+	}
+	*l = License(obj.Type)
+	return nil
+}
+
 type Unpublished struct {
 	Time     string   `json:"time"`
 	Versions []string `json:"versions"`
@@ -113,13 +159,34 @@ func (t *Time) UnmarshalJSON(data []byte) error {
 }
 
 type Dist struct {
-	Tarball string `json:"tarball"`
+	Tarball   string `json:"tarball"`
+	Shasum    string `json:"shasum"`
+	Integrity string `json:"integrity"`
 }
 
 // Packument Version
 
 type Bugs struct {
-	Url string `json:"url"`
+	Url   string `json:"url"`
+	Email string `json:"email,omitempty"`
+}
+
+// packument.bugs can be a url string or an object with url and email. Support both
+func (b *Bugs) UnmarshalJSON(data []byte) error {
+	var obj struct {
+		Url   string `json:"url"`
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(data, &obj); err == nil {
+		*b = Bugs{Url: obj.Url, Email: obj.Email}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*b = Bugs{Url: s}
+	return nil
 }
 
 type PackageVersion struct {
@@ -132,6 +199,53 @@ type PackageVersion struct {
 	Homepage   string            `json:"homepage"`
 	Bugs       Bugs              `json:"bugs"`
 	NpmUser    Contact           `json:"_npmUser"`
+	// populated from either bundleDependencies or the legacy bundledDependencies
+	BundleDependencies []string `json:"bundleDependencies,omitempty"`
+	HasShrinkwrap      bool     `json:"_hasShrinkwrap"`
+	License            License  `json:"license,omitempty"`
+}
+
+// returns true if the version declares a preinstall, install or
+// postinstall script, which npm runs on install.
+func (pv *PackageVersion) HasInstallScripts() bool {
+	for _, s := range []string{"preinstall", "install", "postinstall"} {
+		if _, ok := pv.Scripts[s]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// bundleDependencies is usually a list of names, but may also be a
+// boolean (true meaning all dependencies are bundled). The boolean form,
+// and any other malformed value, is ignored.
+type bundleList []string
+
+func (b *bundleList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*b = list
+	}
+	return nil
+}
+
+// accepts both the bundleDependencies and the legacy bundledDependencies
+// keys into BundleDependencies.
+func (pv *PackageVersion) UnmarshalJSON(data []byte) error {
+	type packageVersion PackageVersion
+	obj := struct {
+		*packageVersion
+		Bundle  bundleList `json:"bundleDependencies"`
+		Bundled bundleList `json:"bundledDependencies"`
+	}{packageVersion: (*packageVersion)(pv)}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	pv.BundleDependencies = obj.Bundle
+	if pv.BundleDependencies == nil {
+		pv.BundleDependencies = obj.Bundled
+	}
+	return nil
 }
 
 // Packument
@@ -147,11 +261,51 @@ type Packument struct {
 	Readme      string                    `json:"readme,omitempty"`
 	Repository  *Repository               `json:"repository,omitempty"`
 	DistTags    map[string]string         `json:"dist-tags,omitempty"`
+	License     License                   `json:"license,omitempty"`
+	Users       map[string]bool           `json:"users,omitempty"`
 	Rev         string                    `json:"_rev"` // couchdb _rev property
+	ID          string                    `json:"_id"`  // couchdb _id property, normally equal to Name
 }
 
 var ErrPackageNotFound = errors.New("packument not found")
 
+// returned when the registry responds 200 OK with an `{"error": "..."}` body
+var ErrRegistryError = errors.New("registry returned an error object")
+
+// decoded alongside a response to detect an error object
+type registryError struct {
+	Error string `json:"error"`
+}
+
+// returns ErrRegistryError wrapping the message, or nil if no error was set
+func (e registryError) err() error {
+	if e.Error == "" {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrRegistryError, e.Error)
+}
+
+// returns the package name, falling back to the couchdb _id for
+// malformed packuments with no name.
+func (packument *Packument) CanonicalName() string {
+	if packument.Name != "" {
+		return packument.Name
+	}
+	return packument.ID
+}
+
+// returns the number of npm users who starred the package, zero if the
+// packument has no users field.
+func (packument *Packument) StarCount() int {
+	n := 0
+	for _, starred := range packument.Users {
+		if starred {
+			n++
+		}
+	}
+	return n
+}
+
 // returns true if the packument suggests npm have issued
 // a holding package (i.e. package taken down)
 func (packument *Packument) IsHoldingPackage() bool {
@@ -184,45 +338,137 @@ func (packument *Packument) Latest() *PackageVersion {
 	return nil
 }
 
+// decode packuments fetched by GetPackument with decode instead of the
+// default JSON decoder, e.g. to decode into an extended type and convert
+// down, or to handle unusual documents leniently. decode is responsible for
+// detecting registry error objects if needed.
+func (c *RegistryClient) WithPackumentDecoder(decode func(io.Reader) (*Packument, error)) *RegistryClient {
+	c.packumentDecoder = decode
+	return c
+}
+
 // retrieves the full packument and returns an unmarshalled
 // Packument struct.
 // Equivalent to GETing https://registry.npmjs.com/{package}
 func (c *RegistryClient) GetPackument(ctx context.Context, id string) (*Packument, error) {
+	if c.cache != nil {
+		if p, ok := c.cache.get(id); ok {
+			return p, nil
+		}
+	}
 	body, err := c.FetchPackument(ctx, id)
 
 	if err != nil {
 		return nil, fmt.Errorf("fetching packument for %s: %w", id, err)
 	}
 	defer body.Close()
+	decode := c.packumentDecoder
+	if decode == nil {
+		decode = decodePackument
+	}
+	r, debug := c.DebugReader(body)
+	packument, err := decode(r)
+	if errors.Is(err, ErrRegistryError) {
+		return nil, fmt.Errorf("%s: %w", id, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling packument for %s: %w", id, debug(err))
+	}
+	if c.cache != nil {
+		c.cache.put(id, packument)
+	}
+
+	return packument, nil
+}
+
+// the default packument decoder, returning an ErrRegistryError if the body
+// is a registry error object.
+func decodePackument(r io.Reader) (*Packument, error) {
 	var packument Packument
-	err = json.NewDecoder(body).Decode(&packument)
+	var regErr registryError
+	err := json.NewDecoder(r).Decode(&struct {
+		*Packument
+		*registryError
+	}{&packument, &regErr})
 	if err != nil {
-		return nil, fmt.Errorf("unmarshalling packument for %s: %w", id, err)
+		return nil, err
+	}
+	if err := regErr.err(); err != nil {
+		return nil, err
 	}
+	return &packument, nil
+}
+
+// discards a JSON value without allocating it
+type skipField struct{}
+
+func (skipField) UnmarshalJSON([]byte) error { return nil }
+
+// retrieves the full packument like GetPackument, but drops the readme
+// during decoding. The readme is often the bulk of the payload, so this
+// meaningfully cuts allocations when following at high volume.
+func (c *RegistryClient) GetPackumentLite(ctx context.Context, id string) (*Packument, error) {
+	body, err := c.FetchPackument(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("fetching packument for %s: %w", id, err)
+	}
+	defer body.Close()
+	r, debug := c.DebugReader(body)
+	packument, err := decodePackumentLite(r)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling packument for %s: %w", id, debug(err))
+	}
+	return packument, nil
+}
 
+func decodePackumentLite(r io.Reader) (*Packument, error) {
+	var packument Packument
+	// the shallower Readme field shadows Packument.Readme
+	var regErr registryError
+	lite := struct {
+		*Packument
+		*registryError
+		Readme skipField `json:"readme"`
+	}{Packument: &packument, registryError: &regErr}
+	if err := json.NewDecoder(r).Decode(&lite); err != nil {
+		return nil, err
+	}
+	if err := regErr.err(); err != nil {
+		return nil, err
+	}
 	return &packument, nil
 }
 
 // fetches the Packument for a given package name.
 // retuns an io.ReadCloser for decoding or reading.
 func (c *RegistryClient) FetchPackument(ctx context.Context, id string) (io.ReadCloser, error) {
+	body, _, err := c.FetchPackumentWithMeta(ctx, id)
+	return body, err
+}
+
+// same as FetchPackument, but additionally returns the response headers
+// (e.g. npm-notice, date, rate-limit counters) for inspection.
+func (c *RegistryClient) FetchPackumentWithMeta(ctx context.Context, id string) (io.ReadCloser, http.Header, error) {
 	packageName := url.PathEscape(id)
 	req, err := http.NewRequestWithContext(ctx, "GET", "https://registry.npmjs.com/"+packageName, nil)
 	if err != nil {
-		return nil, fmt.Errorf("packument fetch: `%s`: creating request: %w", packageName, err)
+		return nil, nil, fmt.Errorf("packument fetch: `%s`: creating request: %w", packageName, err)
 	}
-	res, err := c.Client.Do(req)
+	res, err := c.Do(req)
 
 	if err != nil {
-		return nil, fmt.Errorf("packument fetch: `%s`: performing request: %w", packageName, err)
+		return nil, nil, fmt.Errorf("packument fetch: `%s`: performing request: %w", packageName, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		drainAndClose(res.Body)
 	}
 	if res.StatusCode == http.StatusNotFound {
-		return nil, ErrPackageNotFound
+		return nil, res.Header, ErrPackageNotFound
 	}
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("packument fetch: `%s`: unexpected status code %d from %s", packageName, res.StatusCode, res.Request.URL)
+		return nil, res.Header, fmt.Errorf("packument fetch: `%s`: unexpected status code %d from %s", packageName, res.StatusCode, res.Request.URL)
 	}
-	return res.Body, nil
+	return c.warnLarge(id, res.ContentLength, c.LimitBody(res.Body)), res.Header, nil
 }
 
 // returns an unmarshalled Package Version manifest. This is
@@ -233,9 +479,26 @@ func (c *RegistryClient) GetLatestVersionManifest(ctx context.Context, id string
 		return nil, fmt.Errorf("fetching latest for %s: %w", id, err)
 	}
 	defer body.Close()
+	// manifests are small, so buffer the body to check for an error
+	// object before decoding (PackageVersion has its own unmarshaler)
+	r, debug := c.DebugReader(body)
+	var raw json.RawMessage
+	err = debug(json.NewDecoder(r).Decode(&raw))
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling manifest for %s: %w", id, err)
+	}
+	var regErr registryError
+	if json.Unmarshal(raw, &regErr) == nil {
+		if err := regErr.err(); err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+	}
 	var manifest PackageVersion
-	err = json.NewDecoder(body).Decode(&manifest)
+	err = json.Unmarshal(raw, &manifest)
 	if err != nil {
+		if c.decodeDebug {
+			err = fmt.Errorf("%w (body: %q)", err, raw[:min(len(raw), debugSnippetSize)])
+		}
 		return nil, fmt.Errorf("unmarshalling manifest for %s: %w", id, err)
 	}
 	return &manifest, nil
@@ -244,21 +507,250 @@ func (c *RegistryClient) GetLatestVersionManifest(ctx context.Context, id string
 // fetches the latest version manifest for a given package name.
 // retuns an io.ReadCloser for decoding or reading.
 func (c *RegistryClient) FetchLatestVersionManifest(ctx context.Context, id string) (io.ReadCloser, error) {
+	body, _, err := c.FetchLatestVersionManifestWithMeta(ctx, id)
+	return body, err
+}
+
+// same as FetchLatestVersionManifest, but additionally returns the response headers.
+func (c *RegistryClient) FetchLatestVersionManifestWithMeta(ctx context.Context, id string) (io.ReadCloser, http.Header, error) {
 	packageName := url.PathEscape(id)
 	req, err := http.NewRequestWithContext(ctx, "GET", "https://registry.npmjs.com/"+packageName+"/latest", nil)
 	if err != nil {
-		return nil, fmt.Errorf("latest fetch: `%s`: creating request: %w", packageName, err)
+		return nil, nil, fmt.Errorf("latest fetch: `%s`: creating request: %w", packageName, err)
 	}
-	res, err := c.Client.Do(req)
+	res, err := c.Do(req)
 
 	if err != nil {
-		return nil, fmt.Errorf("latest fetch: `%s`: performing request: %w", packageName, err)
+		return nil, nil, fmt.Errorf("latest fetch: `%s`: performing request: %w", packageName, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		drainAndClose(res.Body)
 	}
 	if res.StatusCode == http.StatusNotFound {
-		return nil, ErrPackageNotFound
+		return nil, res.Header, ErrPackageNotFound
 	}
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("latest fetch: `%s`: unexpected status code %d from %s", packageName, res.StatusCode, res.Request.URL)
+		return nil, res.Header, fmt.Errorf("latest fetch: `%s`: unexpected status code %d from %s", packageName, res.StatusCode, res.Request.URL)
+	}
+	return c.LimitBody(res.Body), res.Header, nil
+}
+
+// returns true if a package exists on the registry. A HEAD request is
+// issued against the packument URL so the body is never downloaded.
+func (c *RegistryClient) PackageExists(ctx context.Context, id string) (bool, error) {
+	packageName := url.PathEscape(id)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", "https://registry.npmjs.com/"+packageName, nil)
+	if err != nil {
+		return false, fmt.Errorf("package exists: `%s`: creating request: %w", packageName, err)
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("package exists: `%s`: performing request: %w", packageName, err)
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("package exists: `%s`: unexpected status code %d from %s", packageName, res.StatusCode, res.Request.URL)
+	}
+}
+
+// returns the Package Version manifest that was published most recently
+// according to packument.time, regardless of dist-tags. Returns nil if no
+// version has a publish time.
+func (packument *Packument) MostRecentVersion() *PackageVersion {
+	var newest string
+	var newestTime time.Time
+	for v := range packument.Versions {
+		t, err := time.Parse(time.RFC3339, packument.Time.VersionTimes[v])
+		if err != nil {
+			continue
+		}
+		if newest == "" || t.After(newestTime) {
+			newest, newestTime = v, t
+		}
+	}
+	if newest == "" {
+		return nil
+	}
+	pv := packument.Versions[newest]
+	return &pv
+}
+
+// returned by TimeSinceLastPublish when the packument has no parseable
+// modified or version publish time
+var ErrNoPublishTime = errors.New("no publish time")
+
+// returns how long ago the package last changed, from time.modified or,
+// failing that, the most recent version publish time.
+func (packument *Packument) TimeSinceLastPublish() (time.Duration, error) {
+	last, err := time.Parse(time.RFC3339, packument.Time.Modified)
+	if err != nil {
+		found := false
+		for _, v := range packument.Time.VersionTimes {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				continue
+			}
+			if !found || t.After(last) {
+				last, found = t, true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("time since last publish: `%s`: %w", packument.CanonicalName(), ErrNoPublishTime)
+		}
+	}
+	return time.Since(last), nil
+}
+
+// returns the versions published after the given time, oldest first.
+// Versions without a parseable publish time are omitted.
+func (packument *Packument) VersionsSince(after time.Time) []PackageVersion {
+	type published struct {
+		version PackageVersion
+		at      time.Time
+	}
+	var recent []published
+	for v, pv := range packument.Versions {
+		t, err := time.Parse(time.RFC3339, packument.Time.VersionTimes[v])
+		if err != nil || !t.After(after) {
+			continue
+		}
+		recent = append(recent, published{pv, t})
+	}
+	slices.SortFunc(recent, func(a, b published) int {
+		return a.at.Compare(b.at)
+	})
+	versions := make([]PackageVersion, 0, len(recent))
+	for _, r := range recent {
+		versions = append(versions, r.version)
+	}
+	return versions
+}
+
+// fetches the packument (without its readme) and returns the versions
+// published after the given time, oldest first. See VersionsSince.
+func (c *RegistryClient) GetVersionsSince(ctx context.Context, id string, after time.Time) ([]PackageVersion, error) {
+	p, err := c.GetPackumentLite(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return p.VersionsSince(after), nil
+}
+
+// drops all but the n most recently published versions from Versions and
+// Time.VersionTimes, ranked by publish time. Versions without a parseable
+// publish time are dropped. Dist-tags may be left pointing at pruned
+// versions. n <= 0 leaves the packument untouched.
+func (packument *Packument) KeepRecentVersions(n int) {
+	if n <= 0 {
+		return
+	}
+	type published struct {
+		version string
+		at      time.Time
+	}
+	var all []published
+	for v := range packument.Versions {
+		t, err := time.Parse(time.RFC3339, packument.Time.VersionTimes[v])
+		if err != nil {
+			continue
+		}
+		all = append(all, published{v, t})
+	}
+	slices.SortFunc(all, func(a, b published) int {
+		return b.at.Compare(a.at)
+	})
+	keep := make(map[string]bool, n)
+	for _, p := range all[:min(n, len(all))] {
+		keep[p.version] = true
+	}
+	for v := range packument.Versions {
+		if !keep[v] {
+			delete(packument.Versions, v)
+			delete(packument.Time.VersionTimes, v)
+		}
+	}
+}
+
+// fetches the packument (without its readme) keeping only the n most
+// recently published versions, to save memory when following recent
+// activity on packages with thousands of versions. n must be at least 1.
+// See KeepRecentVersions.
+func (c *RegistryClient) GetPackumentRecentVersions(ctx context.Context, id string, n int) (*Packument, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("recent versions: `%s`: n must be at least 1, got %d", id, n)
+	}
+	p, err := c.GetPackumentLite(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	p.KeepRecentVersions(n)
+	return p, nil
+}
+
+// returns the versions published by the given npm user, in no particular
+// order. Useful when investigating a suspected account takeover.
+func (packument *Packument) VersionsByUser(username string) []string {
+	var versions []string
+	for v, pv := range packument.Versions {
+		if pv.NpmUser.Name == username {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// returns the keywords lowercased and trimmed, with empty entries and
+// duplicates removed. Order of first occurrence is preserved.
+func (packument *Packument) NormalizedKeywords() []string {
+	seen := make(map[string]bool, len(packument.Keywords))
+	keywords := make([]string, 0, len(packument.Keywords))
+	for _, k := range packument.Keywords {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		keywords = append(keywords, k)
+	}
+	return keywords
+}
+
+// returns the tarball URL of the version matching the `latest` dist-tag,
+// and whether one was found.
+func (packument *Packument) LatestTarball() (string, bool) {
+	latest := packument.Latest()
+	if latest == nil || latest.Dist.Tarball == "" {
+		return "", false
+	}
+	return latest.Dist.Tarball, true
+}
+
+// returns a map of version to tarball URL for every version, e.g. for
+// mirroring a package. Versions without a tarball are omitted.
+func (packument *Packument) AllTarballs() map[string]string {
+	tarballs := make(map[string]string, len(packument.Versions))
+	for v, pv := range packument.Versions {
+		if pv.Dist.Tarball != "" {
+			tarballs[v] = pv.Dist.Tarball
+		}
+	}
+	return tarballs
+}
+
+// returns the tarball URL for the latest version of a package. Only the
+// latest version manifest is fetched, not the full packument.
+func (c *RegistryClient) GetLatestTarballURL(ctx context.Context, id string) (string, error) {
+	manifest, err := c.GetLatestVersionManifest(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if manifest.Dist.Tarball == "" {
+		return "", fmt.Errorf("latest manifest for %s has no tarball", id)
 	}
-	return res.Body, nil
+	return manifest.Dist.Tarball, nil
 }
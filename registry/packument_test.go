@@ -0,0 +1,557 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDecodePackumentLite(t *testing.T) {
+	body := `{
+		"name": "pino",
+		"_rev": "1-abc",
+		"readme": "# a very long readme",
+		"dist-tags": {"latest": "1.0.0"},
+		"versions": {"1.0.0": {"name": "pino", "version": "1.0.0", "readme": "# per-version readme"}},
+		"time": {"created": "2025-01-01T00:00:00.000Z", "1.0.0": "2025-01-01T00:00:00.000Z"}
+	}`
+	p, err := decodePackumentLite(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if p.Readme != "" {
+		t.Errorf("expected readme to be dropped, got %q", p.Readme)
+	}
+	if p.Name != "pino" || p.Rev != "1-abc" {
+		t.Errorf("unexpected name/rev: %s %s", p.Name, p.Rev)
+	}
+	if p.Latest() == nil || p.Latest().Version != "1.0.0" {
+		t.Errorf("expected latest version 1.0.0")
+	}
+}
+
+// serves canned responses, recording each request. bodies, if set, are
+// served in order with the last one repeating; otherwise every request
+// gets body.
+type stubDoer struct {
+	mu       sync.Mutex
+	status   int
+	body     string
+	bodies   []string
+	header   http.Header
+	requests []*http.Request
+}
+
+func (d *stubDoer) Do(req *http.Request) (*http.Response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests = append(d.requests, req)
+	body := d.body
+	if len(d.bodies) > 0 {
+		body = d.bodies[min(len(d.requests), len(d.bodies))-1]
+	}
+	header := d.header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		StatusCode: d.status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     header,
+		Request:    req,
+	}, nil
+}
+
+// returns the number of requests served so far
+func (d *stubDoer) calls() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.requests)
+}
+
+func TestGetPackumentStub(t *testing.T) {
+	ctx := t.Context()
+	c := NewClient().WithDoer(&stubDoer{status: http.StatusOK, body: `{"name": "pino", "_rev": "2-def"}`})
+	p, err := c.GetPackument(ctx, "pino")
+	if err != nil {
+		t.Fatalf("getting packument: %v", err)
+	}
+	if p.Rev != "2-def" {
+		t.Errorf("unexpected rev %s", p.Rev)
+	}
+
+	c = NewClient().WithDoer(&stubDoer{status: http.StatusNotFound})
+	if _, err := c.GetPackument(ctx, "pino"); !errors.Is(err, ErrPackageNotFound) {
+		t.Errorf("expected ErrPackageNotFound, got %v", err)
+	}
+}
+
+func TestRegistryErrorObject(t *testing.T) {
+	ctx := t.Context()
+	c := NewClient().WithDoer(&stubDoer{status: http.StatusOK, body: `{"error": "service unavailable"}`})
+	if _, err := c.GetPackument(ctx, "pino"); !errors.Is(err, ErrRegistryError) {
+		t.Errorf("GetPackument: expected ErrRegistryError, got %v", err)
+	}
+	if _, err := c.GetPackumentLite(ctx, "pino"); !errors.Is(err, ErrRegistryError) {
+		t.Errorf("GetPackumentLite: expected ErrRegistryError, got %v", err)
+	}
+	if _, err := c.GetLatestVersionManifest(ctx, "pino"); !errors.Is(err, ErrRegistryError) {
+		t.Errorf("GetLatestVersionManifest: expected ErrRegistryError, got %v", err)
+	}
+	if _, err := c.GetPackagesForUser(ctx, "kmsec-uk"); !errors.Is(err, ErrRegistryError) {
+		t.Errorf("GetPackagesForUser: expected ErrRegistryError, got %v", err)
+	}
+	// error objects with extra fields are still detected
+	c.WithDoer(&stubDoer{status: http.StatusOK, body: `{"error": "not_found", "reason": "missing"}`})
+	if _, err := c.GetPackagesForUser(ctx, "kmsec-uk"); !errors.Is(err, ErrRegistryError) {
+		t.Errorf("GetPackagesForUser with reason: expected ErrRegistryError, got %v", err)
+	}
+}
+
+func TestPackageExists(t *testing.T) {
+	testCases := []struct {
+		status  int
+		want    bool
+		wantErr bool
+	}{
+		{http.StatusOK, true, false},
+		{http.StatusNotFound, false, false},
+		{http.StatusServiceUnavailable, false, true},
+	}
+	for _, tc := range testCases {
+		d := &stubDoer{status: tc.status}
+		got, err := NewClient().WithDoer(d).PackageExists(t.Context(), "@types/node")
+		if got != tc.want || (err != nil) != tc.wantErr {
+			t.Errorf("status %d: got %v, %v", tc.status, got, err)
+		}
+		if req := d.requests[0]; req.Method != "HEAD" || req.URL.EscapedPath() != "/@types%2Fnode" {
+			t.Errorf("status %d: unexpected request %s %s", tc.status, req.Method, req.URL.EscapedPath())
+		}
+	}
+}
+
+func TestFetchWithMeta(t *testing.T) {
+	ctx := t.Context()
+	header := http.Header{"Npm-Notice": {"hello"}, "X-Ratelimit-Remaining": {"99"}}
+	d := &stubDoer{status: http.StatusOK, body: `{"name": "pino"}`, header: header}
+	c := NewClient().WithDoer(d)
+	body, got, err := c.FetchPackumentWithMeta(ctx, "pino")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	if got.Get("npm-notice") != "hello" || got.Get("x-ratelimit-remaining") != "99" {
+		t.Errorf("unexpected packument headers: %v", got)
+	}
+	body, got, err = c.FetchLatestVersionManifestWithMeta(ctx, "pino")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	if got.Get("npm-notice") != "hello" {
+		t.Errorf("unexpected manifest headers: %v", got)
+	}
+	// headers are surfaced on errors too, e.g. to read a rate limit reset
+	d.status = http.StatusNotFound
+	if _, got, err := c.FetchPackumentWithMeta(ctx, "pino"); !errors.Is(err, ErrPackageNotFound) || got.Get("x-ratelimit-remaining") != "99" {
+		t.Errorf("expected headers with ErrPackageNotFound, got %v, %v", got, err)
+	}
+}
+
+func TestWithPackumentDecoder(t *testing.T) {
+	type extended struct {
+		Packument
+		Custom string `json:"x-custom"`
+	}
+	var custom string
+	c := NewClient().WithDoer(&stubDoer{status: http.StatusOK, body: `{"name": "pino", "x-custom": "hello"}`})
+	c.WithPackumentDecoder(func(r io.Reader) (*Packument, error) {
+		var e extended
+		if err := json.NewDecoder(r).Decode(&e); err != nil {
+			return nil, err
+		}
+		custom = e.Custom
+		return &e.Packument, nil
+	})
+	p, err := c.GetPackument(t.Context(), "pino")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "pino" || custom != "hello" {
+		t.Errorf("custom decoder not used: name %q, custom %q", p.Name, custom)
+	}
+}
+
+func TestCanonicalName(t *testing.T) {
+	var p Packument
+	if err := json.Unmarshal([]byte(`{"_id": "pino", "name": ""}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.CanonicalName(); got != "pino" {
+		t.Errorf("expected fallback to _id, got %q", got)
+	}
+	p.Name = "pino-renamed"
+	if got := p.CanonicalName(); got != "pino-renamed" {
+		t.Errorf("expected name, got %q", got)
+	}
+}
+
+func TestStarCount(t *testing.T) {
+	var p Packument
+	if err := json.Unmarshal([]byte(`{"name": "pino", "users": {"kmsec-uk": true, "someone": true, "unstarred": false}}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.StarCount() != 2 || !p.Users["kmsec-uk"] {
+		t.Errorf("unexpected stars: %d, %v", p.StarCount(), p.Users)
+	}
+	if n := (&Packument{}).StarCount(); n != 0 {
+		t.Errorf("expected 0 stars without users, got %d", n)
+	}
+}
+
+func TestBundleDependencies(t *testing.T) {
+	testCases := []struct {
+		name       string
+		manifest   string
+		want       []string
+		shrinkwrap bool
+	}{
+		{"current key", `{"version": "1.0.0", "bundleDependencies": ["a", "b"]}`, []string{"a", "b"}, false},
+		{"legacy key", `{"version": "1.0.0", "bundledDependencies": ["c"], "_hasShrinkwrap": true}`, []string{"c"}, true},
+		{"boolean form", `{"version": "1.0.0", "bundleDependencies": true}`, nil, false},
+		{"object form", `{"version": "1.0.0", "bundleDependencies": {"a": "1.0.0"}}`, nil, false},
+		{"string form", `{"version": "1.0.0", "bundleDependencies": "a"}`, nil, false},
+	}
+	for _, tc := range testCases {
+		var pv PackageVersion
+		if err := json.Unmarshal([]byte(tc.manifest), &pv); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !slices.Equal(pv.BundleDependencies, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, pv.BundleDependencies, tc.want)
+		}
+		if pv.HasShrinkwrap != tc.shrinkwrap {
+			t.Errorf("%s: HasShrinkwrap = %v", tc.name, pv.HasShrinkwrap)
+		}
+		if pv.Version != "1.0.0" {
+			t.Errorf("%s: version not decoded", tc.name)
+		}
+	}
+}
+
+func TestRepositoryDirectory(t *testing.T) {
+	var r Repository
+	data := `{"type": "git", "url": "git+https://github.com/babel/babel.git", "directory": "packages/foo"}`
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		t.Fatalf("unmarshalling: %v", err)
+	}
+	if r.Directory != "packages/foo" || r.Type != "git" || r.URL != "git+https://github.com/babel/babel.git" {
+		t.Errorf("unexpected repository: %+v", r)
+	}
+}
+
+func TestBugs(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+		want Bugs
+	}{
+		{"string", `"https://github.com/pinojs/pino/issues"`, Bugs{Url: "https://github.com/pinojs/pino/issues"}},
+		{"object", `{"url": "https://github.com/pinojs/pino/issues", "email": "bugs@example.com"}`, Bugs{Url: "https://github.com/pinojs/pino/issues", Email: "bugs@example.com"}},
+		{"email only", `{"email": "bugs@example.com"}`, Bugs{Email: "bugs@example.com"}},
+	}
+	for _, tc := range testCases {
+		var b Bugs
+		if err := json.Unmarshal([]byte(tc.data), &b); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if b != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, b, tc.want)
+		}
+	}
+}
+
+func TestGetAdvisoriesStub(t *testing.T) {
+	body := `{"lodash": [{"id": 1106913, "url": "https://github.com/advisories/GHSA-jf85-cpcp-j695", "title": "Prototype Pollution in lodash", "severity": "critical", "vulnerable_versions": "<4.17.12"}]}`
+	c := NewClient().WithDoer(&stubDoer{status: http.StatusOK, body: body})
+	advisories, err := c.GetAdvisories(t.Context(), "lodash", "4.17.11")
+	if err != nil {
+		t.Fatalf("getting advisories: %v", err)
+	}
+	if len(advisories) != 1 || advisories[0].Severity != "critical" || advisories[0].VulnerableVersions != "<4.17.12" {
+		t.Errorf("unexpected advisories: %+v", advisories)
+	}
+}
+
+func TestDecodeReadme(t *testing.T) {
+	testCases := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"present", `{"name": "pino", "versions": {"1.0.0": {"readme": "nested"}}, "readme": "# pino"}`, "# pino"},
+		{"absent", `{"name": "pino", "versions": {}}`, ""},
+	}
+	for _, tc := range testCases {
+		got, err := decodeReadme(strings.NewReader(tc.body))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDecodeReadmeRegistryError(t *testing.T) {
+	_, err := decodeReadme(strings.NewReader(`{"error": "not_found"}`))
+	if !errors.Is(err, ErrRegistryError) {
+		t.Errorf("expected ErrRegistryError, got %v", err)
+	}
+}
+
+func TestNormalizedKeywords(t *testing.T) {
+	p := &Packument{Keywords: []string{"Logger", " logger ", "", "   ", "JSON", "fast", "json"}}
+	want := []string{"logger", "json", "fast"}
+	if got := p.NormalizedKeywords(); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := (&Packument{}).NormalizedKeywords(); len(got) != 0 {
+		t.Errorf("expected no keywords, got %v", got)
+	}
+}
+
+func TestLatestTarball(t *testing.T) {
+	p := &Packument{
+		DistTags: map[string]string{"latest": "1.0.0"},
+		Versions: map[string]PackageVersion{"1.0.0": {Dist: Dist{Tarball: "https://registry.npmjs.org/pino/-/pino-1.0.0.tgz"}}},
+	}
+	if url, ok := p.LatestTarball(); !ok || url != "https://registry.npmjs.org/pino/-/pino-1.0.0.tgz" {
+		t.Errorf("got %q, %v", url, ok)
+	}
+	if _, ok := (&Packument{}).LatestTarball(); ok {
+		t.Error("expected no tarball for empty packument")
+	}
+}
+
+func TestAllTarballs(t *testing.T) {
+	p := &Packument{
+		Versions: map[string]PackageVersion{
+			"1.0.0": {Dist: Dist{Tarball: "https://registry.npmjs.org/pino/-/pino-1.0.0.tgz"}},
+			"1.1.0": {Dist: Dist{Tarball: "https://registry.npmjs.org/pino/-/pino-1.1.0.tgz"}},
+			"1.2.0": {},
+		},
+	}
+	want := map[string]string{
+		"1.0.0": "https://registry.npmjs.org/pino/-/pino-1.0.0.tgz",
+		"1.1.0": "https://registry.npmjs.org/pino/-/pino-1.1.0.tgz",
+	}
+	if got := p.AllTarballs(); !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestVersionsSince(t *testing.T) {
+	p := &Packument{
+		Versions: map[string]PackageVersion{
+			"1.0.0": {Version: "1.0.0"},
+			"1.1.0": {Version: "1.1.0"},
+			"1.2.0": {Version: "1.2.0"},
+			"2.0.0": {Version: "2.0.0"},
+		},
+		Time: Time{VersionTimes: map[string]string{
+			"1.0.0": "2025-01-01T00:00:00.000Z",
+			"1.1.0": "2025-06-01T00:00:00.000Z",
+			"1.2.0": "2025-03-01T00:00:00.000Z",
+		}},
+	}
+	var got []string
+	for _, v := range p.VersionsSince(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		got = append(got, v.Version)
+	}
+	if want := []string{"1.2.0", "1.1.0"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTimeSinceLastPublish(t *testing.T) {
+	recent := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	p := &Packument{Time: Time{VersionTimes: map[string]string{
+		"1.0.0": "2020-01-01T00:00:00.000Z",
+		"1.1.0": recent,
+	}}}
+	d, err := p.TimeSinceLastPublish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d < time.Hour || d > 2*time.Hour {
+		t.Errorf("expected about an hour from the newest version, got %v", d)
+	}
+	if _, err := (&Packument{}).TimeSinceLastPublish(); !errors.Is(err, ErrNoPublishTime) {
+		t.Errorf("expected ErrNoPublishTime, got %v", err)
+	}
+}
+
+func TestGetPackumentRecentVersions(t *testing.T) {
+	body := `{"name": "pino",
+		"versions": {"1.0.0": {"version": "1.0.0"}, "1.1.0": {"version": "1.1.0"}, "2.0.0": {"version": "2.0.0"}, "3.0.0": {"version": "3.0.0"}},
+		"time": {"modified": "2025-09-01T00:00:00.000Z", "1.0.0": "2025-01-01T00:00:00.000Z", "1.1.0": "2025-06-01T00:00:00.000Z", "2.0.0": "2025-03-01T00:00:00.000Z", "3.0.0": "2025-09-01T00:00:00.000Z"}}`
+	c := NewClient().WithDoer(&stubDoer{status: http.StatusOK, body: body})
+	p, err := c.GetPackumentRecentVersions(t.Context(), "pino", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := slices.Sorted(maps.Keys(p.Versions))
+	if want := []string{"1.1.0", "3.0.0"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if len(p.Time.VersionTimes) != 2 || p.Time.Modified == "" {
+		t.Errorf("unexpected times: %+v", p.Time)
+	}
+	for _, n := range []int{0, -1} {
+		if _, err := c.GetPackumentRecentVersions(t.Context(), "pino", n); err == nil {
+			t.Errorf("expected an error for n = %d", n)
+		}
+		p.KeepRecentVersions(n)
+		if len(p.Versions) != 2 {
+			t.Errorf("KeepRecentVersions(%d) should be a no-op, got %v", n, p.Versions)
+		}
+	}
+}
+
+func TestStreamVersions(t *testing.T) {
+	body := `{"_id": "pino", "readme": "big", "versions": {"1.0.0": {"version": "1.0.0"}, "1.1.0": {"version": "1.1.0"}}, "dist-tags": {"latest": "1.1.0"}}`
+	c := NewClient().WithDoer(&stubDoer{status: http.StatusOK, body: body})
+	var got []string
+	err := c.StreamVersions(t.Context(), "pino", func(pv PackageVersion) error {
+		got = append(got, pv.Version)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.0.0", "1.1.0"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	stop := errors.New("stop")
+	err = c.StreamVersions(t.Context(), "pino", func(PackageVersion) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("expected callback error, got %v", err)
+	}
+
+	c.WithDoer(&stubDoer{status: http.StatusOK, body: `{"error": "service unavailable"}`})
+	err = c.StreamVersions(t.Context(), "pino", func(PackageVersion) error { return nil })
+	if !errors.Is(err, ErrRegistryError) {
+		t.Errorf("expected ErrRegistryError, got %v", err)
+	}
+}
+
+func TestVersionsByUser(t *testing.T) {
+	p := &Packument{Versions: map[string]PackageVersion{
+		"1.0.0": {NpmUser: Contact{Name: "alice"}},
+		"1.1.0": {NpmUser: Contact{Name: "alice"}},
+		"1.2.0": {NpmUser: Contact{Name: "mallory"}},
+	}}
+	got := p.VersionsByUser("alice")
+	slices.Sort(got)
+	if want := []string{"1.0.0", "1.1.0"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := p.VersionsByUser("mallory"); !slices.Equal(got, []string{"1.2.0"}) {
+		t.Errorf("got %v, want [1.2.0]", got)
+	}
+}
+
+func TestPackumentCache(t *testing.T) {
+	now := time.Now()
+	d := &stubDoer{status: http.StatusOK, body: `{"name": "pino"}`}
+	c := NewClient().WithDoer(d).WithPackumentCache(time.Minute, 1)
+	c.cache.now = func() time.Time { return now }
+	ctx := t.Context()
+
+	for range 3 {
+		if _, err := c.GetPackument(ctx, "pino"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := d.calls(); n != 1 {
+		t.Errorf("expected 1 fetch, got %d", n)
+	}
+	if hits, misses := c.PackumentCacheStats(); hits != 2 || misses != 1 {
+		t.Errorf("expected 2 hits, 1 miss, got %d, %d", hits, misses)
+	}
+	// expired
+	now = now.Add(time.Minute)
+	c.GetPackument(ctx, "pino")
+	// evicted by a newer entry
+	c.GetPackument(ctx, "sonic-boom")
+	c.GetPackument(ctx, "pino")
+	// explicitly evicted
+	if !c.EvictPackument("pino") {
+		t.Error("expected pino to be cached")
+	}
+	c.GetPackument(ctx, "pino")
+	if n := d.calls(); n != 5 {
+		t.Errorf("expected 5 fetches, got %d", n)
+	}
+}
+
+func TestLargePackumentWarn(t *testing.T) {
+	var sizes []int64
+	c := NewClient().WithLargePackumentWarn(10, func(id string, size int64) { sizes = append(sizes, size) })
+	body := `{"name": "pino", "_rev": "2-def"}`
+
+	// known length, reported up front
+	c.warnLarge("pino", int64(len(body)), io.NopCloser(strings.NewReader(body)))
+	// under the threshold
+	c.warnLarge("pino", 5, io.NopCloser(strings.NewReader(body)))
+	// unknown length, reported once the threshold is passed
+	r := c.warnLarge("pino", -1, io.NopCloser(strings.NewReader(body)))
+	if _, err := io.Copy(io.Discard, io.MultiReader(io.LimitReader(r, 8), r)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{int64(len(body)), int64(len(body))}; !slices.Equal(sizes, want) {
+		t.Errorf("got %v, want %v", sizes, want)
+	}
+
+	// a nil callback turns the check off
+	c.WithLargePackumentWarn(10, nil)
+	if r := c.warnLarge("pino", -1, io.NopCloser(strings.NewReader(body))); r == nil {
+		t.Fatal("expected the body back")
+	} else if _, ok := r.(*sizeWatcher); ok {
+		t.Error("expected no size watcher with a nil callback")
+	}
+}
+
+func TestMaintainersStringForm(t *testing.T) {
+	var p Packument
+	data := `{"name": "pino", "maintainers": [
+		{"name": "kmsec-uk", "email": "me@example.com"},
+		"Jane Doe <jane@example.com> (https://example.com)",
+		"bob <bob@example.com>",
+		"alice"
+	]}`
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		t.Fatal(err)
+	}
+	want := []Contact{
+		{Name: "kmsec-uk", Email: "me@example.com"},
+		{Name: "Jane Doe", Email: "jane@example.com", URL: "https://example.com"},
+		{Name: "bob", Email: "bob@example.com"},
+		{Name: "alice"},
+	}
+	if !slices.Equal(p.Maintainers, want) {
+		t.Errorf("got %+v, want %+v", p.Maintainers, want)
+	}
+}
@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// returns only the readme of a package. The packument is streamed and every
+// other top-level field is skipped rather than decoded. Packages without a
+// readme return an empty string.
+func (c *RegistryClient) GetReadme(ctx context.Context, id string) (string, error) {
+	body, err := c.FetchPackument(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("fetching packument for %s: %w", id, err)
+	}
+	defer body.Close()
+	readme, err := decodeReadme(body)
+	if err != nil {
+		return "", fmt.Errorf("extracting readme for %s: %w", id, err)
+	}
+	return readme, nil
+}
+
+func decodeReadme(r io.Reader) (string, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return "", err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch key {
+		case "error":
+			var regErr registryError
+			if err := dec.Decode(&regErr.Error); err != nil {
+				return "", err
+			}
+			if err := regErr.err(); err != nil {
+				return "", err
+			}
+		case "readme":
+			var readme string
+			// a non-string readme is treated as absent
+			if err := dec.Decode(&readme); err != nil {
+				return "", nil
+			}
+			return readme, nil
+		default:
+			if err := dec.Decode(&skipField{}); err != nil {
+				return "", err
+			}
+		}
+	}
+	return "", nil
+}
+
+// reads the next token and checks it is the given delimiter
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected %v, got %v", delim, tok)
+	}
+	return nil
+}
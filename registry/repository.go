@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"net/url"
+	"strings"
+)
+
+// hosts for npm's `host:owner/repo` repository shorthands
+var shorthandHosts = map[string]string{
+	"github":    "github.com",
+	"gitlab":    "gitlab.com",
+	"bitbucket": "bitbucket.org",
+	"gist":      "gist.github.com",
+}
+
+// returns a browseable https:// URL for the repository, normalizing the
+// common git URL forms (git+https, git+ssh, git://, scp-style git@host:path
+// and the github:owner/repo shorthand). The Directory is appended as a
+// tree path when set. Returns an empty string if the URL cannot be parsed.
+func (r *Repository) BrowserURL() string {
+	raw := strings.TrimSpace(r.URL)
+	if raw == "" {
+		return ""
+	}
+	// drop any committish fragment
+	raw, _, _ = strings.Cut(raw, "#")
+
+	var host, path string
+	switch {
+	case strings.Contains(raw, "://"):
+		u, err := url.Parse(strings.TrimPrefix(raw, "git+"))
+		if err != nil || u.Host == "" {
+			return ""
+		}
+		host, path = u.Hostname(), u.Path
+	case strings.HasPrefix(raw, "git@"):
+		// scp-like git@github.com:owner/repo.git
+		h, p, ok := strings.Cut(strings.TrimPrefix(raw, "git@"), ":")
+		if !ok {
+			return ""
+		}
+		host, path = h, p
+	default:
+		// shorthand github:owner/repo, or bare owner/repo for github
+		prefix, p, ok := strings.Cut(raw, ":")
+		if !ok {
+			prefix, p = "github", raw
+		}
+		h, known := shorthandHosts[prefix]
+		if !known {
+			return ""
+		}
+		host, path = h, p
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if host == "" || path == "" {
+		return ""
+	}
+	browser := "https://" + host + "/" + path
+	if dir := strings.Trim(r.Directory, "/"); dir != "" {
+		tree := "/tree/HEAD/"
+		if host == "gitlab.com" {
+			tree = "/-/tree/HEAD/"
+		}
+		browser += tree + dir
+	}
+	return browser
+}
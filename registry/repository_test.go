@@ -0,0 +1,32 @@
+package registry
+
+import "testing"
+
+func TestBrowserURL(t *testing.T) {
+	testCases := []struct {
+		name string
+		repo Repository
+		want string
+	}{
+		{"git+https", Repository{URL: "git+https://github.com/pinojs/pino.git"}, "https://github.com/pinojs/pino"},
+		{"git+ssh", Repository{URL: "git+ssh://git@github.com/pinojs/pino.git"}, "https://github.com/pinojs/pino"},
+		{"git protocol", Repository{URL: "git://github.com/pinojs/pino.git"}, "https://github.com/pinojs/pino"},
+		{"scp-like", Repository{URL: "git@github.com:pinojs/pino.git"}, "https://github.com/pinojs/pino"},
+		{"https with fragment", Repository{URL: "https://github.com/pinojs/pino#main"}, "https://github.com/pinojs/pino"},
+		{"github shorthand", Repository{URL: "github:pinojs/pino"}, "https://github.com/pinojs/pino"},
+		{"bare shorthand", Repository{URL: "pinojs/pino"}, "https://github.com/pinojs/pino"},
+		{"gitlab shorthand", Repository{URL: "gitlab:owner/repo"}, "https://gitlab.com/owner/repo"},
+		{
+			"monorepo directory",
+			Repository{URL: "git+https://github.com/babel/babel.git", Directory: "packages/babel-core"},
+			"https://github.com/babel/babel/tree/HEAD/packages/babel-core",
+		},
+		{"empty", Repository{}, ""},
+		{"unknown shorthand", Repository{URL: "svn:something"}, ""},
+	}
+	for _, tc := range testCases {
+		if got := tc.repo.BrowserURL(); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
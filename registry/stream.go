@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// streams the versions of a package's packument, calling fn with each
+// version manifest in document order. Versions are decoded one at a time
+// and not retained, so memory use is bounded by the largest single version
+// rather than the whole packument. Other top-level fields are skipped. If
+// fn returns an error, streaming stops and that error is returned.
+func (c *RegistryClient) StreamVersions(ctx context.Context, id string, fn func(PackageVersion) error) error {
+	body, err := c.FetchPackument(ctx, id)
+	if err != nil {
+		return fmt.Errorf("fetching packument for %s: %w", id, err)
+	}
+	defer body.Close()
+	return decodeVersions(body, id, fn)
+}
+
+func decodeVersions(r io.Reader, id string, fn func(PackageVersion) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return fmt.Errorf("streaming versions for %s: %w", id, err)
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("streaming versions for %s: %w", id, err)
+		}
+		switch key {
+		case "error":
+			var regErr registryError
+			if err := dec.Decode(&regErr.Error); err != nil {
+				return fmt.Errorf("streaming versions for %s: %w", id, err)
+			}
+			if err := regErr.err(); err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+		case "versions":
+			return streamVersionsObject(dec, id, fn)
+		default:
+			if err := dec.Decode(&skipField{}); err != nil {
+				return fmt.Errorf("streaming versions for %s: %w", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// walks the versions object, decoding one manifest at a time
+func streamVersionsObject(dec *json.Decoder, id string, fn func(PackageVersion) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return fmt.Errorf("streaming versions for %s: %w", id, err)
+	}
+	for dec.More() {
+		v, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("streaming versions for %s: %w", id, err)
+		}
+		var pv PackageVersion
+		if err := dec.Decode(&pv); err != nil {
+			return fmt.Errorf("streaming versions for %s: version %v: %w", id, v, err)
+		}
+		if err := fn(pv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
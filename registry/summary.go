@@ -0,0 +1,37 @@
+package registry
+
+import "time"
+
+// a compact overview of a Packument for triage
+type PackumentSummary struct {
+	VersionCount  int
+	LatestVersion string
+	// -1 if no version has a usable publish time
+	DaysSinceLastPublish int
+	MaintainerCount      int
+	// true if the `latest` version has install scripts
+	HasInstallScripts bool
+	License           string
+}
+
+// computes a PackumentSummary from the packument's fields.
+func (packument *Packument) Summary() PackumentSummary {
+	s := PackumentSummary{
+		VersionCount:         len(packument.Versions),
+		DaysSinceLastPublish: -1,
+		MaintainerCount:      len(packument.Maintainers),
+		License:              string(packument.License),
+	}
+	if latest := packument.Latest(); latest != nil {
+		s.LatestVersion = latest.Version
+		s.HasInstallScripts = latest.HasInstallScripts()
+		if s.License == "" {
+			s.License = string(latest.License)
+		}
+	}
+	if newest := packument.MostRecentVersion(); newest != nil {
+		published, _ := time.Parse(time.RFC3339, packument.Time.VersionTimes[newest.Version])
+		s.DaysSinceLastPublish = int(time.Since(published).Hours() / 24)
+	}
+	return s
+}
@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSummary(t *testing.T) {
+	published := time.Now().Add(-72 * time.Hour).UTC().Format(time.RFC3339)
+	p := &Packument{
+		Versions: map[string]PackageVersion{
+			"1.0.0": {Version: "1.0.0"},
+			"1.1.0": {Version: "1.1.0", Scripts: map[string]string{"postinstall": "node setup.js"}},
+		},
+		DistTags:    map[string]string{"latest": "1.1.0"},
+		Maintainers: []Contact{{Name: "alice"}},
+		License:     "MIT",
+		Time: Time{VersionTimes: map[string]string{
+			"1.0.0": "2020-01-01T00:00:00.000Z",
+			"1.1.0": published,
+		}},
+	}
+	s := p.Summary()
+	if s.VersionCount != 2 || s.LatestVersion != "1.1.0" || s.MaintainerCount != 1 {
+		t.Errorf("unexpected counts: %+v", s)
+	}
+	if !s.HasInstallScripts {
+		t.Error("expected install scripts")
+	}
+	if s.License != "MIT" {
+		t.Errorf("unexpected license %q", s.License)
+	}
+	if s.DaysSinceLastPublish != 3 {
+		t.Errorf("days since last publish: got %d, want 3", s.DaysSinceLastPublish)
+	}
+	if empty := (&Packument{}).Summary(); empty.DaysSinceLastPublish != -1 {
+		t.Errorf("expected -1 days for empty packument, got %d", empty.DaysSinceLastPublish)
+	}
+}
+
+func TestLicenseShapes(t *testing.T) {
+	testCases := map[string]License{
+		`"MIT"`:                              "MIT",
+		`{"type": "ISC", "url": "x"}`:        "ISC",
+		`[{"type": "MIT"}, {"type": "GPL"}]`: "",
+		`true`:                               "",
+	}
+	for data, want := range testCases {
+		var p Packument
+		if err := json.Unmarshal([]byte(`{"name": "pino", "license": `+data+`}`), &p); err != nil {
+			t.Errorf("%s: unexpected error %v", data, err)
+			continue
+		}
+		if p.License != want {
+			t.Errorf("%s: got %q, want %q", data, p.License, want)
+		}
+	}
+}
@@ -3,14 +3,59 @@ package registry
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 )
 
+// returned by user endpoints when the user does not exist
+var ErrUserNotFound = errors.New("user not found")
+
 // returns a map[string]string of a user's maintained packages and permissions associated.
 // equivalent to GETing https://registry.npmjs.com/-/user/{user}/package
 func (c *RegistryClient) GetPackagesForUser(ctx context.Context, user string) (map[string]string, error) {
+	body, err := c.FetchPackagesForUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(body)
+	r, debug := c.DebugReader(body)
+	var raw map[string]json.RawMessage
+	err = debug(json.NewDecoder(r).Decode(&raw))
+	if err != nil {
+		return nil, fmt.Errorf("GetPackages: `%s`: decoding response: %w", user, err)
+	}
+	m := make(map[string]string, len(raw))
+	errorShaped := false
+	for k, v := range raw {
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			return nil, fmt.Errorf("GetPackages: `%s`: decoding permission for %s: %w", user, k, err)
+		}
+		m[k] = s
+		if s != "read" && s != "write" {
+			errorShaped = true
+		}
+	}
+	// a package may legitimately be named "error", so only treat the body
+	// as an error object ({"error": ..., "reason": ...}) when something in
+	// it isn't a permission
+	if _, ok := m["error"]; ok && errorShaped {
+		if err := (registryError{Error: m["error"]}).err(); err != nil {
+			if reason := m["reason"]; reason != "" {
+				return nil, fmt.Errorf("GetPackages: `%s`: %w (%s)", user, err, reason)
+			}
+			return nil, fmt.Errorf("GetPackages: `%s`: %w", user, err)
+		}
+	}
+	return m, nil
+}
+
+// fetches a user's maintained packages and permissions.
+// retuns an io.ReadCloser for decoding or reading.
+func (c *RegistryClient) FetchPackagesForUser(ctx context.Context, user string) (io.ReadCloser, error) {
 
 	// i don't think usernames are permitted to be url unsafe, but let's make it safe anyway
 	path := url.PathEscape(user)
@@ -19,21 +64,66 @@ func (c *RegistryClient) GetPackagesForUser(ctx context.Context, user string) (m
 	if err != nil {
 		return nil, fmt.Errorf("GetPackages: `%s`: creating request: %w", user, err)
 	}
-	res, err := c.Client.Do(req)
+	res, err := c.Do(req)
 
 	if err != nil {
 		return nil, fmt.Errorf("GetPackages: `%s`: performing request: %w", user, err)
 	}
+	if res.StatusCode != http.StatusOK {
+		drainAndClose(res.Body)
+	}
 	if res.StatusCode == http.StatusNotFound {
-		return nil, ErrPackageNotFound
+		return nil, ErrUserNotFound
 	}
 	if res.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("GetPackages: `%s`: unexpected status code %d from %s", user, res.StatusCode, res.Request.URL)
 	}
-	var m map[string]string
-	err = json.NewDecoder(res.Body).Decode(&m)
+	return c.LimitBody(res.Body), nil
+}
+
+// a user's public profile. Fields the user hasn't set are empty.
+type UserProfile struct {
+	Name     string `json:"name"`
+	FullName string `json:"fullname,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Homepage string `json:"homepage,omitempty"`
+	GitHub   string `json:"github,omitempty"`
+	Twitter  string `json:"twitter,omitempty"`
+	Created  string `json:"created,omitempty"`
+}
+
+// returns the public profile for a user.
+// equivalent to GETing https://registry.npmjs.com/-/user/org.couchdb.user:{user}
+func (c *RegistryClient) GetUserProfile(ctx context.Context, user string) (*UserProfile, error) {
+	path := url.PathEscape("org.couchdb.user:" + user)
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://registry.npmjs.com/-/user/"+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("GetUserProfile: `%s`: creating request: %w", user, err)
+	}
+	req.Header.Add("user-agent", c.UserAgent)
+	res, err := c.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("GetPackages: `%s`: decoding response: %w", user, err)
+		return nil, fmt.Errorf("GetUserProfile: `%s`: performing request: %w", user, err)
 	}
-	return m, nil
+	defer drainAndClose(res.Body)
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrUserNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GetUserProfile: `%s`: unexpected status code %d from %s", user, res.StatusCode, res.Request.URL)
+	}
+	var profile UserProfile
+	var regErr registryError
+	r, debug := c.DebugReader(c.LimitBody(res.Body))
+	err = debug(json.NewDecoder(r).Decode(&struct {
+		*UserProfile
+		*registryError
+	}{&profile, &regErr}))
+	if err != nil {
+		return nil, fmt.Errorf("GetUserProfile: `%s`: decoding response: %w", user, err)
+	}
+	if err := regErr.err(); err != nil {
+		return nil, fmt.Errorf("GetUserProfile: `%s`: %w", user, err)
+	}
+	return &profile, nil
 }
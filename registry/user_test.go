@@ -1,7 +1,9 @@
 package registry
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"testing"
 )
 
@@ -12,31 +14,69 @@ func TestGetPackages(t *testing.T) {
 	testCases := []struct {
 		name    string
 		user    string
-		wantErr bool
+		wantErr error
 	}{{
 		name:    "me <3",
 		user:    "kmsec-uk",
-		wantErr: false,
+		wantErr: nil,
 	}, {
 		name:    "exists but no management of packages",
 		user:    "topflite8",
-		wantErr: false,
+		wantErr: nil,
 	}, {
 		name:    "nonexistent",
 		user:    "ldfvkposiiovxoopiaiupdoi",
-		wantErr: true,
+		wantErr: ErrUserNotFound,
 	}}
 	for _, tc := range testCases {
 		pkgs, err := testClient.GetPackagesForUser(ctx, tc.user)
 
-		if err != nil {
-			if !tc.wantErr {
-				t.Errorf("TestGetPackages: %s: %v", tc.name, err)
-				continue
-			}
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("TestGetPackages: %s: got %v, want %v", tc.name, err, tc.wantErr)
+			continue
 		}
 		for pkg, perm := range pkgs {
 			fmt.Printf("%s manages %s with %s permission\n", tc.user, pkg, perm)
 		}
 	}
 }
+
+func TestGetPackagesForUserStub(t *testing.T) {
+	c := NewClient().WithDoer(&stubDoer{status: http.StatusOK, body: `{"pino": "write", "error": "read"}`})
+	pkgs, err := c.GetPackagesForUser(t.Context(), "kmsec-uk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 2 || pkgs["pino"] != "write" {
+		t.Errorf("unexpected packages: %v", pkgs)
+	}
+	for _, body := range []string{
+		`{"error": "not_found", "reason": "missing"}`,
+		`{"error": "read", "reason": "user is locked"}`,
+		`{"error": "internal_server_error"}`,
+	} {
+		c.WithDoer(&stubDoer{status: http.StatusOK, body: body})
+		if _, err := c.GetPackagesForUser(t.Context(), "kmsec-uk"); !errors.Is(err, ErrRegistryError) {
+			t.Errorf("%s: expected ErrRegistryError, got %v", body, err)
+		}
+	}
+	c.WithDoer(&stubDoer{status: http.StatusNotFound})
+	if _, err := c.FetchPackagesForUser(t.Context(), "nobody"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestGetUserProfileStub(t *testing.T) {
+	c := NewClient().WithDoer(&stubDoer{status: http.StatusOK, body: `{"_id": "org.couchdb.user:kmsec-uk", "name": "kmsec-uk", "email": "me@example.com", "github": "kmsec-uk"}`})
+	p, err := c.GetUserProfile(t.Context(), "kmsec-uk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "kmsec-uk" || p.Email != "me@example.com" || p.GitHub != "kmsec-uk" {
+		t.Errorf("unexpected profile: %+v", p)
+	}
+	c.WithDoer(&stubDoer{status: http.StatusNotFound})
+	if _, err := c.GetUserProfile(t.Context(), "nobody"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,11 @@
+package registry
+
+import "strings"
+
+// returns true if a semver version string has a prerelease component,
+// e.g. 1.0.0-beta.1, 2.0.0-rc.0 or 0.0.1-security. Build metadata
+// (+build) is ignored.
+func IsPrerelease(version string) bool {
+	core, _, _ := strings.Cut(version, "+")
+	return strings.Contains(core, "-")
+}
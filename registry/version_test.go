@@ -0,0 +1,22 @@
+package registry
+
+import "testing"
+
+func TestIsPrerelease(t *testing.T) {
+	testCases := []struct {
+		version string
+		want    bool
+	}{
+		{"1.0.0", false},
+		{"1.0.0-beta.1", true},
+		{"2.0.0-rc.0", true},
+		{"0.0.1-security", true},
+		{"1.2.3+build-5", false},
+		{"1.2.3-canary.1+build", true},
+	}
+	for _, tc := range testCases {
+		if got := IsPrerelease(tc.version); got != tc.want {
+			t.Errorf("IsPrerelease(%q) = %v, want %v", tc.version, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package registry
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/kmsec-uk/npm-follower/clock"
+)
+
+// the kind of change a WatchEvent describes
+type WatchEventKind int
+
+const (
+	// a new version was published. Version is set.
+	WatchNewVersion WatchEventKind = iota
+	// a dist-tag moved. DistTag is set.
+	WatchDistTagChange
+	// the maintainer list changed. MaintainersBefore and MaintainersAfter are set.
+	WatchMaintainerChange
+)
+
+func (k WatchEventKind) String() string {
+	switch k {
+	case WatchNewVersion:
+		return "new version"
+	case WatchDistTagChange:
+		return "dist-tag change"
+	case WatchMaintainerChange:
+		return "maintainer change"
+	}
+	return "unknown"
+}
+
+// a change to a watched package. Which fields are set depends on Kind.
+type WatchEvent struct {
+	Kind              WatchEventKind
+	Package           string
+	Version           *PackageVersion
+	DistTag           DistTagChange
+	MaintainersBefore []Contact
+	MaintainersAfter  []Contact
+}
+
+// polls the packument for a single package every interval and emits an
+// event for each newly-published version, dist-tag move and maintainer
+// change. The first poll only records a baseline snapshot, so nothing
+// existing is emitted. Failed polls are logged and retried on the next
+// tick; see Watcher.WithOnError to handle them instead. An interval <= 0
+// uses the default of one minute. The channel is closed when ctx is done.
+func WatchPackage(ctx context.Context, client *RegistryClient, id string, interval time.Duration) <-chan WatchEvent {
+	return NewWatcher(client, id, interval).Watch(ctx)
+}
+
+// polling interval used by NewWatcher when given one <= 0
+const defaultWatchInterval = time.Minute
+
+// number of events the channel returned by Watch holds before polling
+// blocks on the consumer
+const watchBufferSize = 10
+
+// Watcher is a configurable WatchPackage.
+type Watcher struct {
+	client   *RegistryClient
+	id       string
+	interval time.Duration
+	tags     []string
+	clock    clock.Clock
+	onError  func(error)
+}
+
+// returns a Watcher polling id every interval. An interval <= 0 uses the
+// default of one minute.
+func NewWatcher(client *RegistryClient, id string, interval time.Duration) *Watcher {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	return &Watcher{client: client, id: id, interval: interval, clock: clock.New()}
+}
+
+// use a custom Clock for the polling ticker, e.g. clock.Fake in tests.
+func (w *Watcher) WithClock(c clock.Clock) *Watcher {
+	w.clock = c
+	return w
+}
+
+// call fn on the polling goroutine with each failed poll instead of logging
+// it. The poll is retried on the next tick either way.
+func (w *Watcher) WithOnError(fn func(error)) *Watcher {
+	w.onError = fn
+	return w
+}
+
+// only emit dist-tag changes for the given tags, e.g. "latest", "next" and
+// "canary". By default every dist-tag is watched.
+func (w *Watcher) WithWatchedTags(tags []string) *Watcher {
+	w.tags = tags
+	return w
+}
+
+// start polling, see WatchPackage. The channel buffers up to 10 events;
+// once it is full, polling waits for the consumer to catch up rather than
+// dropping events.
+func (w *Watcher) Watch(ctx context.Context) <-chan WatchEvent {
+	out := make(chan WatchEvent, watchBufferSize)
+	go func() {
+		defer close(out)
+		ticker := w.clock.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		var last *Packument
+		poll := func() {
+			p, err := w.client.GetPackumentLite(ctx, w.id)
+			if err != nil {
+				err = fmt.Errorf("watch %s: %w", w.id, err)
+				if w.onError != nil {
+					w.onError(err)
+				} else {
+					log.Printf("%v\n", err)
+				}
+				return
+			}
+			if last == nil {
+				last = p
+				return
+			}
+			events := w.events(last, p)
+			last = p
+			for _, e := range events {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		poll()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				poll()
+			}
+		}
+	}()
+	return out
+}
+
+// computes the events between two snapshots, dropping dist-tag changes for
+// tags that aren't watched
+func (w *Watcher) events(old, new *Packument) []WatchEvent {
+	events := watchEvents(w.id, old, new)
+	if w.tags == nil {
+		return events
+	}
+	return slices.DeleteFunc(events, func(e WatchEvent) bool {
+		return e.Kind == WatchDistTagChange && !slices.Contains(w.tags, e.DistTag.Tag)
+	})
+}
+
+// computes the events between two snapshots of a packument
+func watchEvents(id string, old, new *Packument) []WatchEvent {
+	diff := DiffPackuments(old, new)
+	var events []WatchEvent
+
+	// new versions in publish order where the publish time is known
+	added := diff.AddedVersions
+	slices.SortStableFunc(added, func(a, b string) int {
+		return cmp.Compare(new.Time.VersionTimes[a], new.Time.VersionTimes[b])
+	})
+	for _, v := range added {
+		pv := new.Versions[v]
+		events = append(events, WatchEvent{Kind: WatchNewVersion, Package: id, Version: &pv})
+	}
+	for _, change := range diff.DistTagChanges {
+		events = append(events, WatchEvent{Kind: WatchDistTagChange, Package: id, DistTag: change})
+	}
+	if len(diff.AddedMaintainers) > 0 || len(diff.RemovedMaintainers) > 0 {
+		events = append(events, WatchEvent{
+			Kind:              WatchMaintainerChange,
+			Package:           id,
+			MaintainersBefore: old.Maintainers,
+			MaintainersAfter:  new.Maintainers,
+		})
+	}
+	return events
+}
@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kmsec-uk/npm-follower/clock"
+)
+
+func TestWatchEventsMaintainerChange(t *testing.T) {
+	old := &Packument{Maintainers: []Contact{{Name: "alice"}}}
+	new := &Packument{Maintainers: []Contact{{Name: "mallory"}}}
+	events := watchEvents("pino", old, new)
+	if len(events) != 1 || events[0].Kind != WatchMaintainerChange {
+		t.Fatalf("expected a single maintainer change, got %+v", events)
+	}
+	e := events[0]
+	if e.MaintainersBefore[0].Name != "alice" || e.MaintainersAfter[0].Name != "mallory" {
+		t.Errorf("unexpected maintainer sets: %+v -> %+v", e.MaintainersBefore, e.MaintainersAfter)
+	}
+}
+
+func TestWatchedTags(t *testing.T) {
+	old := &Packument{DistTags: map[string]string{"latest": "1.0.0", "next": "2.0.0-beta.1", "canary": "2.0.0-canary.1"}}
+	new := &Packument{DistTags: map[string]string{"latest": "1.0.0", "next": "2.0.0-beta.2", "canary": "2.0.0-canary.2"}}
+	w := NewWatcher(NewClient(), "pino", time.Minute).WithWatchedTags([]string{"latest", "next"})
+	events := w.events(old, new)
+	if len(events) != 1 || events[0].Kind != WatchDistTagChange {
+		t.Fatalf("expected a single dist-tag change, got %+v", events)
+	}
+	want := DistTagChange{Tag: "next", From: "2.0.0-beta.1", To: "2.0.0-beta.2"}
+	if events[0].DistTag != want {
+		t.Errorf("got %+v, want %+v", events[0].DistTag, want)
+	}
+}
+
+func TestWatchClock(t *testing.T) {
+	d := &stubDoer{status: http.StatusOK, bodies: []string{
+		`{"name": "pino", "maintainers": [{"name": "alice"}]}`,
+		`{"name": "pino", "maintainers": [{"name": "mallory"}]}`,
+	}}
+	fake := clock.NewFake(time.Now())
+	events := NewWatcher(NewClient().WithDoer(d), "pino", time.Minute).WithClock(fake).Watch(t.Context())
+
+	// the baseline poll runs straight away, the next only once the ticker fires
+	fake.BlockUntil(1)
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event before the first tick: %+v", e)
+	default:
+	}
+	fake.Advance(time.Minute)
+	e := <-events
+	if e.Kind != WatchMaintainerChange || e.MaintainersAfter[0].Name != "mallory" {
+		t.Errorf("expected a maintainer change, got %+v", e)
+	}
+}
+
+func TestWatchOnError(t *testing.T) {
+	w := NewWatcher(NewClient().WithDoer(&stubDoer{status: http.StatusInternalServerError}), "pino", 0)
+	if w.interval != defaultWatchInterval {
+		t.Errorf("expected an interval <= 0 to use the default, got %v", w.interval)
+	}
+	errs := make(chan error, 1)
+	w.WithClock(clock.NewFake(time.Now())).WithOnError(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}).Watch(t.Context())
+	if err := <-errs; !strings.Contains(err.Error(), "watch pino") {
+		t.Errorf("expected the error to name the package, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kmsec-uk/npm-follower/event"
+)
+
+// connect and start issuing source-agnostic event.Events to channel. The
+// Timestamp is the item's pubDate, see the README for its caveats.
+func (f *Follower) ConnectEvents(ctx context.Context) <-chan event.Event {
+	in := f.Connect(ctx)
+	out := make(chan event.Event, f.loop.BufferSize)
+	go func() {
+		defer close(out)
+		for result := range in {
+			e := event.Event{
+				Source:      event.SourceRSS,
+				Registry:    rssEndpoint,
+				PackageName: result.FeedItem.PackageName(),
+				Timestamp:   result.ObservedAt,
+				Error:       result.Error,
+			}
+			if d, err := result.FeedItem.Date(); err == nil {
+				e.Timestamp = d
+			}
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// returns the newest item handed to the Connect channel as a ResumeToken.
+// It only moves once an item has been sent, so resuming from it never
+// skips an item the follower fetched but didn't deliver. Items still
+// waiting in the channel buffer count as delivered; use WithBufferSize(0)
+// if those must not be lost when the process exits. The token is empty
+// apart from its Source until the first item has been delivered.
+func (f *Follower) Token() event.ResumeToken {
+	f.sm.Lock()
+	defer f.sm.Unlock()
+	t := event.ResumeToken{Source: event.SourceRSS}
+	if f.delivered != nil {
+		t.GUID = f.delivered.GUID
+		t.PubDate = f.delivered.PubDate
+	}
+	return t
+}
+
+// resume from a token previously returned by Token. Call before Connect.
+// A token with a guid becomes the follower's marker, so the first poll
+// delivers only items newer than it, even ones sharing its pubDate. Without
+// a guid the first poll drops items published at or before the token's
+// pubDate, as with WithSince.
+func (f *Follower) ResumeFrom(t event.ResumeToken) error {
+	if t.Source != event.SourceRSS {
+		return fmt.Errorf("resuming rss follower: %w", event.ErrTokenSource)
+	}
+	if t.GUID != "" {
+		f.sm.Lock()
+		f.latest = &Item{GUID: t.GUID, PubDate: t.PubDate}
+		f.delivered = f.latest
+		f.sm.Unlock()
+		return nil
+	}
+	if t.PubDate == "" {
+		return nil
+	}
+	d, err := time.Parse(time.RFC1123, t.PubDate)
+	if err != nil {
+		return fmt.Errorf("resuming rss follower: parsing pubDate: %w", err)
+	}
+	f.WithSince(d)
+	return nil
+}
@@ -8,19 +8,29 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/kmsec-uk/npm-follower/clock"
+	"github.com/kmsec-uk/npm-follower/internal/poll"
 	"github.com/kmsec-uk/npm-follower/registry"
 )
 
 const rssEndpoint string = "https://registry.npmjs.org/-/rss"
 
+// floor applied by WithPollingInterval
+const defaultMinInterval time.Duration = time.Second
+
 var ErrEmptyFeed = errors.New("feed responded with 0 items")
 
+// returned when Connect is called on a Follower that is already connected
+var ErrAlreadyConnected = poll.ErrAlreadyConnected
+
 // RSS is the top-level container
 type RSSResponse struct {
 	Channel Channel `xml:"channel"`
@@ -33,6 +43,11 @@ type Channel struct {
 	Items         []Item `xml:"item"`
 }
 
+// parses the feed's lastBuildDate
+func (c *Channel) BuildTime() (time.Time, error) {
+	return time.Parse(time.RFC1123, c.LastBuildDate)
+}
+
 type PubDate string
 
 // Item represents a single entry in the feed
@@ -40,6 +55,7 @@ type Item struct {
 	Title   string `xml:"title"`
 	Link    string `xml:"link"`
 	PubDate string `xml:"pubDate"`
+	GUID    string `xml:"guid"`
 
 	// Namespace handling: Use the full URL, not just the "dc" prefix
 	Creator string `xml:"http://purl.org/dc/elements/1.1/ creator"`
@@ -53,6 +69,19 @@ func (i *Item) Date() (time.Time, error) {
 	return time.Parse(time.RFC1123, i.PubDate)
 }
 
+// returns the package name from the item title, trimmed of any
+// surrounding whitespace left over from the CDATA section.
+func (i *Item) PackageName() string {
+	return strings.TrimSpace(i.Title)
+}
+
+// returns the scope of the package without the leading @, e.g.
+// "opencode-ai" for @opencode-ai/plugin. Empty for unscoped packages.
+func (i *Item) Scope() string {
+	scope, _ := registry.SplitPackageName(i.PackageName())
+	return scope
+}
+
 // returns true if Item has equal properties to another Item.
 func (i *Item) Is(other *Item) bool {
 	if i.Creator != other.Creator {
@@ -67,26 +96,82 @@ func (i *Item) Is(other *Item) bool {
 	return true
 }
 
+// reports whether i is the marker item m. Items are matched on guid and
+// pubDate where both have a guid, as titles and dates alone can collide,
+// falling back to Is otherwise.
+func (i *Item) matches(m *Item) bool {
+	if i.GUID != "" && m.GUID != "" {
+		return i.GUID == m.GUID && i.PubDate == m.PubDate
+	}
+	return i.Is(m)
+}
+
 type Result struct {
 	FeedItem Item
 	Error    error
+	// when the follower delivered the Result, for measuring propagation
+	// latency against the item's pubDate
+	ObservedAt time.Time
 }
 
 type Follower struct {
 	*registry.RegistryClient
+	loop      poll.Loop[Result]
+	statusMu  sync.Mutex
+	lastBuild time.Time
+	limit     int
+	since     time.Time
+	latest    *Item
+	// newest item handed to the Connect channel, for Token. Guarded by sm
+	delivered *Item
+	sm        sync.Mutex
+
+	// requested polling interval, floored at minInterval by Connect unless
+	// unsafeInterval is set
 	pollingInterval time.Duration
-	limit           int
-	latest          *Item
-	sm              sync.Mutex
+	minInterval     time.Duration
+	unsafeInterval  bool
+
+	allowEmptyFeed bool
+	failFast       bool
+
+	scope string
+
+	// lastBuildDate of the last feed that was fully evaluated
+	evaluatedBuild string
+
+	lastStats PollStats
+}
+
+// how the last evaluated feed was truncated, for tuning WithLimit. If
+// MarkerFound is regularly false, the limit is too low and items are
+// being missed.
+type PollStats struct {
+	// items newer than the marker, before WithScope filtering
+	New int
+	// items fetched
+	Total int
+	// whether the previous poll's latest item was found. Always true on
+	// the first poll, which has no marker.
+	MarkerFound bool
 }
 
 func NewFollower() *Follower {
-	return &Follower{
+	f := &Follower{
 		RegistryClient:  registry.NewClient(),
 		pollingInterval: 2 * time.Second,
+		minInterval:     defaultMinInterval,
 		limit:           50,
 		latest:          nil,
 	}
+	f.loop.BufferSize = 10
+	f.loop.Clock = clock.New()
+	f.loop.ErrorResult = func(err error) Result { return Result{Error: err} }
+	f.loop.Stamp = func(r Result, at time.Time) Result {
+		r.ObservedAt = at
+		return r
+	}
+	return f
 }
 
 // limit parameter for requesting data from the RSS feed
@@ -95,111 +180,338 @@ func (f *Follower) WithLimit(i int) *Follower {
 	return f
 }
 
+// drop an item rather than block polling when the consumer hasn't received
+// it within d. The marker has already moved past a dropped item, so it is
+// never redelivered: delivery becomes at-most-once. See Dropped. Default is
+// 0, blocking until the consumer is ready.
+func (f *Follower) WithResultTimeout(d time.Duration) *Follower {
+	f.loop.ResultTimeout = d
+	return f
+}
+
+// call fn on the polling goroutine whenever fetching or parsing the feed
+// fails, so errors can be routed to alerting separately from the Result
+// stream. Errors are still delivered as Results unless WithoutErrorResults
+// is set.
+func (f *Follower) WithOnError(fn func(error)) *Follower {
+	f.loop.OnError = fn
+	return f
+}
+
+// stop delivering feed errors such as ErrEmptyFeed as Results, so every
+// Result carries an item. Pair with WithOnError so errors aren't silently
+// lost.
+func (f *Follower) WithoutErrorResults() *Follower {
+	f.loop.SuppressErrors = true
+	return f
+}
+
+// returns the number of items dropped by WithResultTimeout.
+func (f *Follower) Dropped() uint64 {
+	return f.loop.Dropped()
+}
+
+// close the channel after delivering the first feed error, for one-shot
+// jobs that should exit on failure. By default errors are delivered as
+// Results and the feed is polled again on the next tick.
+func (f *Follower) WithFailFast() *Follower {
+	f.failFast = true
+	return f
+}
+
+// only deliver items for packages in the given scope, e.g. "@mycompany".
+// The npm rss endpoint has no scope parameter, so the whole feed is still
+// fetched and filtered client-side: matching items only arrive if they
+// make it into the WithLimit most recent publishes between polls.
+func (f *Follower) WithScope(scope string) *Follower {
+	f.scope = strings.TrimPrefix(scope, "@")
+	return f
+}
+
+// treat an empty feed as a quiet poll with nothing to deliver rather than
+// an ErrEmptyFeed error. By default an empty feed is an error, as it
+// usually means the feed failed to generate.
+func (f *Follower) WithAllowEmptyFeed() *Follower {
+	f.allowEmptyFeed = true
+	return f
+}
+
+// on the first poll, drop items with a pubDate at or before t. Use this
+// to resume from a persisted timestamp without reprocessing old items.
+func (f *Follower) WithSince(t time.Time) *Follower {
+	f.since = t
+	return f
+}
+
+// delay the first feed poll by a random duration up to maxDelay, so
+// followers started together don't all fetch the feed at once.
+func (f *Follower) WithStartupJitter(maxDelay time.Duration) *Follower {
+	f.loop.StartupJitter = maxDelay
+	return f
+}
+
+// use a custom Clock for the polling loop, e.g. clock.Fake in tests.
+func (f *Follower) WithClock(c clock.Clock) *Follower {
+	f.loop.Clock = c
+	return f
+}
+
+// capacity of the Result channel returned by Connect. Default is 10; 0
+// makes the channel unbuffered. Negative sizes are treated as 0.
+func (f *Follower) WithBufferSize(n int) *Follower {
+	f.loop.BufferSize = max(n, 0)
+	return f
+}
+
+// set the polling interval for the follower. Default is 2 seconds, though
+// the feed is cached for around 60 seconds. Connect raises intervals below
+// the minimum (see WithMinInterval) to it with a warning, and t <= 0 is
+// ignored.
 func (f *Follower) WithPollingInterval(t time.Duration) *Follower {
+	if t <= 0 {
+		return f
+	}
 	f.pollingInterval = t
+	f.unsafeInterval = false
 	return f
 }
 
-// connect and start issuing Results to channel.
-func (f *Follower) Connect(ctx context.Context) <-chan Result {
+// set the polling interval without applying the minimum interval floor.
+// The public feed is cached for around 60 seconds, so this only helps
+// against a proxy or mirror of it. t <= 0 is ignored.
+func (f *Follower) WithPollingIntervalUnsafe(t time.Duration) *Follower {
+	if t <= 0 {
+		return f
+	}
+	f.pollingInterval = t
+	f.unsafeInterval = true
+	return f
+}
 
-	out := make(chan Result, 10)
+// set the floor applied to WithPollingInterval. Default is 1 second. It is
+// applied by Connect, whichever order the options are set in.
+func (f *Follower) WithMinInterval(t time.Duration) *Follower {
+	f.minInterval = t
+	return f
+}
 
-	go func() {
-		defer close(out)
-		ticker := time.NewTicker(f.pollingInterval)
-		defer ticker.Stop()
+// the interval Connect polls at
+func (f *Follower) interval() time.Duration {
+	if f.unsafeInterval {
+		return f.pollingInterval
+	}
+	return max(f.pollingInterval, f.minInterval)
+}
 
-		fetch := func() {
-			// hard-stop 10 second context timeout
-			reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-			defer cancel()
+// schedule each feed poll one polling interval after the previous one
+// started, less the time the poll took, instead of on a fixed ticker. A poll
+// longer than the interval is followed immediately by the next. Without it,
+// ticks that fall due during a slow poll are skipped, see SkippedTicks.
+func (f *Follower) WithDriftCorrection() *Follower {
+	f.loop.DriftCorrection = true
+	return f
+}
 
-			rssItems, err := f.getChanges(reqCtx)
-			if err != nil {
-				select {
-				case out <- Result{Error: err}:
-				case <-ctx.Done():
+// returns the number of polling ticks skipped because a poll ran past the
+// polling interval. Always zero with WithDriftCorrection.
+func (f *Follower) SkippedTicks() uint64 {
+	return f.loop.SkippedTicks()
+}
 
-				}
-				return
-			}
+// returns the time the feed was last fetched successfully, or the zero
+// time if no poll has succeeded yet. See LastFeedBuildTime for when the
+// feed itself was generated.
+func (f *Follower) LastPollTime() time.Time {
+	return f.loop.LastPollTime()
+}
 
-			for _, item := range rssItems {
-				select {
-				case out <- Result{FeedItem: item}:
-				case <-ctx.Done():
-					return
-				}
-			}
+// returns the error from the most recent feed poll, or nil if it
+// succeeded.
+func (f *Follower) LastError() error {
+	return f.loop.LastError()
+}
 
+// returns the number of new items from the last successful poll, after
+// WithScope filtering. Zero when the feed hadn't been rebuilt.
+func (f *Follower) LastPollCount() int {
+	return f.loop.LastPollCount()
+}
+
+// returns the truncation stats of the most recently evaluated feed. Polls
+// that skip an unchanged feed leave them as they were.
+func (f *Follower) LastPollStats() PollStats {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+	return f.lastStats
+}
+
+// returns the lastBuildDate of the most recently fetched feed, or the zero
+// time if none has been fetched or it could not be parsed. Compare it with
+// the current time to detect a lagging feed generator.
+func (f *Follower) LastFeedBuildTime() time.Time {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+	return f.lastBuild
+}
+
+// stops polling and closes the channel returned by Connect. Calling Stop
+// before Connect, or more than once, is a no-op.
+func (f *Follower) Stop() {
+	f.loop.Stop()
+}
+
+// connect and start issuing Results to channel. Items are only evaluated
+// when the feed has been rebuilt: a poll returning the same lastBuildDate
+// as the last evaluated feed delivers nothing.
+func (f *Follower) Connect(ctx context.Context) <-chan Result {
+	f.loop.Interval = f.interval()
+	if f.loop.Interval != f.pollingInterval {
+		log.Printf("polling interval %v is below the minimum of %v, using the minimum\n", f.pollingInterval, f.minInterval)
+	}
+	return f.loop.Run(ctx, nil, func(ctx context.Context, out chan<- Result) bool {
+		rssItems, err := f.getChanges(ctx)
+		f.loop.RecordPoll(len(rssItems), err)
+		if err != nil {
+			f.loop.Fail(ctx, out, err)
+			return f.failFast
 		}
 
-		fetch()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				fetch()
+		for _, item := range rssItems {
+			if !f.loop.Send(ctx, out, Result{FeedItem: item}) {
+				return false
 			}
+			f.sm.Lock()
+			f.delivered = &item
+			f.sm.Unlock()
 		}
-	}()
-	return out
+		// the whole batch is out, including items WithScope dropped
+		f.sm.Lock()
+		f.delivered = f.latest
+		f.sm.Unlock()
+		return false
+	})
 }
 
 func (f *Follower) getChanges(ctx context.Context) ([]Item, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", rssEndpoint, nil)
-	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
+	channel, err := f.fetchFeed(ctx)
+	if errors.Is(err, errNotModified) {
+		return []Item{}, nil
 	}
-	// user-agent
-	req.Header.Add("user-agent", f.UserAgent)
-	// sequence
-	q := req.URL.Query()
-	q.Add("descending", "true") // always reverse chronological orders
-	q.Add("limit", strconv.Itoa(f.limit))
-	req.URL.RawQuery = q.Encode()
-	fmt.Println(req.URL.String())
-	res, err := f.Client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("doing request: %w", err)
+		return nil, err
 	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status %v from %s", res.StatusCode, res.Request.URL)
-	}
-	var rr RSSResponse
-	err = xml.NewDecoder(res.Body).Decode(&rr)
-	if err != nil {
-		return nil, fmt.Errorf("decoding body: %w", err)
+	// zero if unparseable, see LastFeedBuildTime
+	built, _ := channel.BuildTime()
+	f.statusMu.Lock()
+	f.lastBuild = built
+	f.statusMu.Unlock()
+	// the feed hasn't been rebuilt since it was last evaluated
+	if channel.LastBuildDate != "" && channel.LastBuildDate == f.evaluatedBuild {
+		return []Item{}, nil
 	}
-	if len(rr.Channel.Items) == 0 {
+	items := channel.Items
+	if len(items) == 0 {
+		if f.allowEmptyFeed {
+			f.evaluatedBuild = channel.LastBuildDate
+			return []Item{}, nil
+		}
 		return nil, ErrEmptyFeed
 	}
+	defer func() { f.evaluatedBuild = channel.LastBuildDate }()
 
-	truncateIndex := len(rr.Channel.Items)
+	truncateIndex := len(items)
+	found := true
 	// identify truncation point
 	if f.latest != nil {
-		for idx, item := range rr.Channel.Items {
-			if item.Is(f.latest) {
+		found = false
+		for idx, item := range items {
+			if item.matches(f.latest) {
+				truncateIndex = idx
+				found = true
+				break
+			}
+		}
+		// the marker fell off the feed (e.g. after downtime), so items
+		// between it and the oldest fetched item are missed. Raise
+		// WithLimit if this shows up regularly
+		if !found {
+			log.Printf("rss gap: marker %s (%s) not found in %d item(s), items may have been missed\n", f.latest.Title, f.latest.PubDate, len(items))
+		}
+	} else if !f.since.IsZero() {
+		// first poll: the feed is descending, so truncate at the first
+		// item published at or before the since watermark
+		for idx, item := range items {
+			if d, err := item.Date(); err == nil && !d.After(f.since) {
 				truncateIndex = idx
 				break
 			}
 		}
 	}
 	// truncate
-	new := rr.Channel.Items[:truncateIndex]
+	new := items[:truncateIndex]
+	f.statusMu.Lock()
+	f.lastStats = PollStats{New: len(new), Total: len(items), MarkerFound: found}
+	f.statusMu.Unlock()
 
 	if len(new) == 0 {
 		return []Item{}, nil
 	}
 	// set latest
 	f.sm.Lock()
-	latest := rr.Channel.Items[0]
+	latest := items[0]
 	f.latest = &latest
 	f.sm.Unlock()
 	// sort
 	slices.Reverse(new)
+	if f.scope != "" {
+		new = slices.DeleteFunc(new, func(item Item) bool {
+			return item.Scope() != f.scope
+		})
+	}
 	return new, nil
 }
+
+// returned by fetchFeed when the feed hasn't changed since the last
+// evaluated build
+var errNotModified = errors.New("not modified")
+
+// fetches the newest WithLimit items of the feed.
+func (f *Follower) fetchFeed(ctx context.Context) (*Channel, error) {
+	// hard-stop 10 second context timeout
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", rssEndpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	// user-agent
+	req.Header.Add("user-agent", f.UserAgent)
+	// sequence
+	q := req.URL.Query()
+	q.Add("descending", "true") // always reverse chronological orders
+	q.Add("limit", strconv.Itoa(f.limit))
+	req.URL.RawQuery = q.Encode()
+	// servers that ignore this respond 200 and the unchanged build is
+	// skipped by getChanges instead
+	if f.evaluatedBuild != "" {
+		req.Header.Set("if-modified-since", f.evaluatedBuild)
+	}
+	res, err := f.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("doing request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v from %s", res.StatusCode, res.Request.URL)
+	}
+	var rr RSSResponse
+	r, debug := f.DebugReader(f.LimitBody(res.Body))
+	err = debug(xml.NewDecoder(r).Decode(&rr))
+	if err != nil {
+		return nil, fmt.Errorf("decoding body: %w", err)
+	}
+	return &rr.Channel, nil
+}
@@ -2,7 +2,18 @@ package rss
 
 import (
 	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/kmsec-uk/npm-follower/clock"
+	"github.com/kmsec-uk/npm-follower/event"
 )
 
 const (
@@ -42,3 +53,331 @@ func TestCompareItem(t *testing.T) {
 
 	}
 }
+
+func TestItemPackageName(t *testing.T) {
+	testCases := []struct {
+		xml, name, scope string
+	}{
+		{item1, "@opencode-ai/plugin", "opencode-ai"},
+		{item2, "@sdjkals/data-lib-kernel", "sdjkals"},
+		{`<item><title><![CDATA[ pino ]]></title></item>`, "pino", ""},
+	}
+	for _, tc := range testCases {
+		var i Item
+		if err := xml.Unmarshal([]byte(tc.xml), &i); err != nil {
+			t.Fatal(err)
+		}
+		if i.PackageName() != tc.name || i.Scope() != tc.scope {
+			t.Errorf("got name %q, scope %q, want %q, %q", i.PackageName(), i.Scope(), tc.name, tc.scope)
+		}
+	}
+}
+
+// serves a descending feed of the given titles, honouring limit
+type feedDoer struct {
+	titles []string
+	// optional guids, parallel to titles
+	guids []string
+	// lastBuildDate, defaults to Sun, 21 Dec 2025 10:08:30 GMT
+	buildDate string
+	// if set, records the if-modified-since header and responds 304 Not
+	// Modified to conditional requests
+	since *string
+}
+
+func (d feedDoer) Do(req *http.Request) (*http.Response, error) {
+	q := req.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if d.since != nil {
+		if *d.since = req.Header.Get("if-modified-since"); *d.since != "" {
+			return &http.Response{
+				StatusCode: http.StatusNotModified,
+				Body:       io.NopCloser(strings.NewReader("")),
+				Request:    req,
+			}, nil
+		}
+	}
+	var b strings.Builder
+	b.WriteString(`<rss xmlns:dc="http://purl.org/dc/elements/1.1/"><channel>`)
+	buildDate := d.buildDate
+	if buildDate == "" {
+		buildDate = "Sun, 21 Dec 2025 10:08:30 GMT"
+	}
+	fmt.Fprintf(&b, `<lastBuildDate>%s</lastBuildDate>`, buildDate)
+	for i := 0; i < len(d.titles) && i < limit; i++ {
+		guid := ""
+		if i < len(d.guids) {
+			guid = d.guids[i]
+		}
+		fmt.Fprintf(&b, `<item><title>%s</title><guid>%s</guid><dc:creator>someone</dc:creator><pubDate>Sun, 21 Dec 2025 10:08:22 GMT</pubDate></item>`, d.titles[i], guid)
+	}
+	b.WriteString(`</channel></rss>`)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(b.String())),
+		Request:    req,
+	}, nil
+}
+
+func TestMarkerNotFound(t *testing.T) {
+	f := NewFollower().WithLimit(2)
+	f.WithDoer(feedDoer{titles: []string{"e", "d", "c", "b", "a"}})
+	f.latest = &Item{Title: "b", Creator: "someone", PubDate: "Sun, 21 Dec 2025 10:08:22 GMT"}
+
+	// b isn't on the fetched page, so everything fetched is new and the
+	// gap shows up in the stats
+	items, err := f.getChanges(t.Context())
+	if err != nil {
+		t.Fatalf("getting changes: %v", err)
+	}
+	var got []string
+	for _, item := range items {
+		got = append(got, item.Title)
+	}
+	if want := []string{"d", "e"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if f.latest.Title != "e" {
+		t.Errorf("latest marker not advanced: %s", f.latest.Title)
+	}
+	if stats := f.LastPollStats(); stats != (PollStats{New: 2, Total: 2, MarkerFound: false}) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestMarkerMatchesGUID(t *testing.T) {
+	f := NewFollower().WithLimit(10)
+	// identical title, creator and pubDate, told apart only by guid
+	f.WithDoer(feedDoer{titles: []string{"c", "x", "x", "a"}, guids: []string{"3", "2", "1", "0"}})
+	f.latest = &Item{Title: "x", Creator: "someone", PubDate: "Sun, 21 Dec 2025 10:08:22 GMT", GUID: "1"}
+	items, err := f.getChanges(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range items {
+		got = append(got, item.GUID)
+	}
+	if want := []string{"2", "3"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTokenAfterDelivery(t *testing.T) {
+	f := NewFollower().WithBufferSize(0).WithClock(clock.NewFake(time.Now()))
+	f.WithDoer(feedDoer{titles: []string{"c", "b", "a"}, guids: []string{"3", "2", "1"}})
+	results := f.Connect(t.Context())
+
+	// the feed has been fetched, but b and c haven't been delivered yet
+	<-results
+	if got := f.Token().GUID; got == "3" {
+		t.Errorf("token moved past undelivered items: %q", got)
+	}
+	<-results
+	<-results
+	f.Stop()
+	for range results {
+	}
+	if got := f.Token().GUID; got != "3" {
+		t.Errorf("expected the token at c once delivered, got %q", got)
+	}
+}
+
+func TestResumeFromSamePubDate(t *testing.T) {
+	f := NewFollower().WithLimit(10)
+	// every item shares a pubDate, so only the guid places the token
+	f.WithDoer(feedDoer{titles: []string{"c", "b", "a"}, guids: []string{"3", "2", "1"}})
+	err := f.ResumeFrom(event.ResumeToken{Source: event.SourceRSS, GUID: "2", PubDate: "Sun, 21 Dec 2025 10:08:22 GMT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := f.getChanges(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].GUID != "3" {
+		t.Errorf("expected only guid 3 after the token, got %+v", items)
+	}
+}
+
+func TestWithScope(t *testing.T) {
+	f := NewFollower().WithScope("@mycompany")
+	f.WithDoer(feedDoer{titles: []string{"pino", "@mycompany/b", "@other/a", "@mycompany/a"}})
+	items, err := f.getChanges(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range items {
+		got = append(got, item.Title)
+	}
+	if want := []string{"@mycompany/a", "@mycompany/b"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if f.latest.Title != "pino" {
+		t.Errorf("marker should track the unfiltered feed, got %s", f.latest.Title)
+	}
+}
+
+func TestLastFeedBuildTime(t *testing.T) {
+	f := NewFollower()
+	f.WithDoer(feedDoer{titles: []string{"a"}})
+	if !f.LastFeedBuildTime().IsZero() {
+		t.Error("expected zero build time before polling")
+	}
+	if _, err := f.getChanges(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2025, 12, 21, 10, 8, 30, 0, time.UTC)
+	if got := f.LastFeedBuildTime(); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSkipUnchangedBuild(t *testing.T) {
+	f := NewFollower()
+	f.WithDoer(feedDoer{titles: []string{"a"}})
+	if items, err := f.getChanges(t.Context()); err != nil || len(items) != 1 {
+		t.Fatalf("first poll: got %v, %v", items, err)
+	}
+	// same build date: not evaluated, even though the items changed
+	f.WithDoer(feedDoer{titles: []string{"b", "a"}})
+	if items, err := f.getChanges(t.Context()); err != nil || len(items) != 0 {
+		t.Errorf("unchanged build: got %v, %v", items, err)
+	}
+	f.WithDoer(feedDoer{titles: []string{"b", "a"}, buildDate: "Sun, 21 Dec 2025 10:09:30 GMT"})
+	items, err := f.getChanges(t.Context())
+	if err != nil || len(items) != 1 || items[0].Title != "b" {
+		t.Errorf("rebuilt feed: got %v, %v", items, err)
+	}
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	var since string
+	f := NewFollower()
+	f.WithDoer(feedDoer{titles: []string{"a"}, since: &since})
+	if items, err := f.getChanges(t.Context()); err != nil || len(items) != 1 || since != "" {
+		t.Fatalf("first poll: got %v, %v, if-modified-since %q", items, err, since)
+	}
+	items, err := f.getChanges(t.Context())
+	if err != nil || len(items) != 0 {
+		t.Errorf("expected quiet poll on 304, got %v, %v", items, err)
+	}
+	if since != "Sun, 21 Dec 2025 10:08:30 GMT" {
+		t.Errorf("unexpected if-modified-since %q", since)
+	}
+}
+
+func TestAllowEmptyFeed(t *testing.T) {
+	f := NewFollower()
+	f.WithDoer(feedDoer{})
+	if _, err := f.getChanges(t.Context()); !errors.Is(err, ErrEmptyFeed) {
+		t.Errorf("expected ErrEmptyFeed by default, got %v", err)
+	}
+	f.WithAllowEmptyFeed()
+	items, err := f.getChanges(t.Context())
+	if err != nil || len(items) != 0 {
+		t.Errorf("expected quiet poll, got %v, %v", items, err)
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	f := NewFollower().WithFailFast().WithClock(clock.NewFake(time.Now()))
+	f.WithDoer(feedDoer{})
+	results := f.Connect(t.Context())
+	if r := <-results; !errors.Is(r.Error, ErrEmptyFeed) {
+		t.Fatalf("expected ErrEmptyFeed, got %+v", r)
+	}
+	select {
+	case _, ok := <-results:
+		if ok {
+			t.Error("expected channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Error("channel not closed after error")
+	}
+}
+
+func TestResultTimeout(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	f := NewFollower().WithBufferSize(0).WithResultTimeout(time.Second).WithClock(fake)
+	f.WithDoer(feedDoer{titles: []string{"c", "b", "a"}})
+	f.Connect(t.Context())
+	defer f.Stop()
+	// nobody is reading, so each item is dropped once the timeout passes.
+	// The ticker is always waiting, alongside the blocked send
+	for range 2 {
+		fake.BlockUntil(2)
+		fake.Advance(time.Second)
+	}
+	// parked on the third send
+	fake.BlockUntil(2)
+	if got := f.Dropped(); got != 2 {
+		t.Errorf("expected 2 dropped results, got %d", got)
+	}
+}
+
+func TestStop(t *testing.T) {
+	f := NewFollower().WithClock(clock.NewFake(time.Now()))
+	f.WithDoer(feedDoer{titles: []string{"b", "a"}})
+	// no-op before Connect
+	f.Stop()
+
+	results := f.Connect(t.Context())
+	for range 2 {
+		if r := <-results; r.Error != nil {
+			t.Fatalf("unexpected error: %v", r.Error)
+		}
+	}
+	f.Stop()
+	f.Stop()
+	select {
+	case _, ok := <-results:
+		if ok {
+			t.Error("expected channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Error("channel not closed after Stop")
+	}
+}
+
+func TestConnectTwice(t *testing.T) {
+	f := NewFollower().WithBufferSize(0).WithClock(clock.NewFake(time.Now()))
+	f.WithDoer(feedDoer{titles: []string{"a"}})
+	first := f.Connect(t.Context())
+	defer f.Stop()
+	r, ok := <-f.Connect(t.Context())
+	if !ok || !errors.Is(r.Error, ErrAlreadyConnected) {
+		t.Errorf("expected ErrAlreadyConnected, got %+v", r)
+	}
+	<-first
+}
+
+func TestMinInterval(t *testing.T) {
+	f := NewFollower().WithPollingInterval(10 * time.Millisecond)
+	if got := f.interval(); got != time.Second {
+		t.Errorf("expected interval clamped to 1s, got %v", got)
+	}
+	f.WithMinInterval(0)
+	if got := f.interval(); got != 10*time.Millisecond {
+		t.Errorf("expected the lowered floor to apply whatever the order, got %v", got)
+	}
+	f.WithMinInterval(time.Second).WithPollingIntervalUnsafe(10 * time.Millisecond)
+	if got := f.interval(); got != 10*time.Millisecond {
+		t.Errorf("expected unsafe interval to bypass floor, got %v", got)
+	}
+}
+
+func TestBufferSize(t *testing.T) {
+	for n, want := range map[int]int{-1: 0, 0: 0, 5: 5} {
+		f := NewFollower().WithBufferSize(n).WithClock(clock.NewFake(time.Now()))
+		f.WithDoer(feedDoer{})
+		results := f.Connect(t.Context())
+		if got := cap(results); got != want {
+			t.Errorf("WithBufferSize(%d): got capacity %d, want %d", n, got, want)
+		}
+		f.Stop()
+		for range results {
+		}
+	}
+}
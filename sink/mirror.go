@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/kmsec-uk/npm-follower/couch"
+	"github.com/kmsec-uk/npm-follower/registry"
+)
+
+// DirMirror mirrors the packument of each change from a couch.Follower to
+// disk, at {Root}/{name}/packument.json, or {Root}/@{scope}/{name}/packument.json
+// for scoped packages.
+type DirMirror struct {
+	Root string
+	// client used to fetch packuments. Defaults to registry.NewClient().
+	Client *registry.RegistryClient
+}
+
+// consumes Results until the channel is closed or ctx is done, stopping at
+// the first error. Error Results from the follower are skipped.
+// Packuments are written atomically, so readers never see a partial file.
+// Deleted changes, and packages no longer on the registry, have their file
+// removed.
+func (m *DirMirror) Consume(ctx context.Context, results <-chan couch.Result) error {
+	client := m.Client
+	if client == nil {
+		client = registry.NewClient()
+	}
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				return nil
+			}
+			if result.Error != nil {
+				continue
+			}
+			if err := m.mirror(ctx, client, result.Change); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// returns the path a package's packument is mirrored to
+func (m *DirMirror) Path(name string) (string, error) {
+	scope, bare := registry.SplitPackageName(name)
+	rel := bare
+	if scope != "" {
+		rel = filepath.Join("@"+scope, bare)
+	}
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("mirror: %s: %w", name, registry.ErrInvalidPackageName)
+	}
+	return filepath.Join(m.Root, rel, "packument.json"), nil
+}
+
+func (m *DirMirror) mirror(ctx context.Context, client *registry.RegistryClient, change couch.CouchDocumentChange) error {
+	path, err := m.Path(change.ID)
+	if err != nil {
+		return err
+	}
+	if change.Deleted {
+		return remove(path)
+	}
+	body, err := client.FetchPackument(ctx, change.ID)
+	if errors.Is(err, registry.ErrPackageNotFound) {
+		return remove(path)
+	}
+	if err != nil {
+		return fmt.Errorf("mirror: %w", err)
+	}
+	defer body.Close()
+	if err := writeAtomic(path, body); err != nil {
+		return fmt.Errorf("mirror: %s: %w", change.ID, err)
+	}
+	return nil
+}
+
+func remove(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("mirror: %w", err)
+	}
+	return nil
+}
+
+// writes r to a temporary file alongside path and renames it into place
+func writeAtomic(path string, r io.Reader) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".packument-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
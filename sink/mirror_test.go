@@ -0,0 +1,54 @@
+package sink
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kmsec-uk/npm-follower/couch"
+	"github.com/kmsec-uk/npm-follower/registry"
+)
+
+// serves a minimal packument named after the request path
+type packumentDoer struct{}
+
+func (packumentDoer) Do(req *http.Request) (*http.Response, error) {
+	name := strings.TrimPrefix(req.URL.Path, "/")
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"name": "` + name + `"}`)),
+		Request:    req,
+	}, nil
+}
+
+func TestDirMirror(t *testing.T) {
+	root := t.TempDir()
+	m := &DirMirror{Root: root, Client: registry.NewClient().WithDoer(packumentDoer{})}
+
+	results := make(chan couch.Result, 4)
+	results <- couch.Result{Change: couch.CouchDocumentChange{ID: "pino"}}
+	results <- couch.Result{Change: couch.CouchDocumentChange{ID: "@types/node"}}
+	results <- couch.Result{Change: couch.CouchDocumentChange{ID: "left-pad"}}
+	results <- couch.Result{Change: couch.CouchDocumentChange{ID: "left-pad", Deleted: true}}
+	close(results)
+	if err := m.Consume(t.Context(), results); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(root, "@types", "node", "packument.json"))
+	if err != nil || string(b) != `{"name": "@types/node"}` {
+		t.Errorf("scoped packument: got %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "pino", "packument.json")); err != nil {
+		t.Errorf("unscoped packument: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "left-pad", "packument.json")); !os.IsNotExist(err) {
+		t.Errorf("expected deleted packument to be removed, got %v", err)
+	}
+	if _, err := m.Path("../etc"); err == nil {
+		t.Error("expected an error for a name escaping the root")
+	}
+}
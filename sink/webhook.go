@@ -0,0 +1,136 @@
+// Package sink provides ready-made consumers for the followers' Result
+// channels.
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kmsec-uk/npm-follower/clock"
+	"github.com/kmsec-uk/npm-follower/couch"
+)
+
+// Webhook POSTs each change from a couch.Follower as JSON to URL.
+type Webhook struct {
+	URL string
+	// http client used for delivery. Defaults to a client with a 10 second timeout.
+	Client *http.Client
+	// number of concurrent deliveries. Defaults to 1, which preserves order.
+	Concurrency int
+	// number of retries after a failed delivery. Default is 0.
+	Retries int
+	// delay between retries. Defaults to 1 second.
+	RetryDelay time.Duration
+	// clock the retry delay is waited on, e.g. clock.Fake in tests.
+	// Defaults to the real clock.
+	Clock clock.Clock
+	// if set, called with changes that could not be delivered after all
+	// retries and Consume carries on. Otherwise Consume stops and returns
+	// the delivery error.
+	DeadLetter func(change couch.CouchDocumentChange, err error)
+}
+
+// consumes Results until the channel is closed or ctx is done. Error
+// Results from the follower are skipped.
+func (w *Webhook) Consume(ctx context.Context, results <-chan couch.Result) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for range max(w.Concurrency, 1) {
+		wg.Go(func() {
+			for {
+				var result couch.Result
+				var ok bool
+				select {
+				case result, ok = <-results:
+					if !ok {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+				if result.Error != nil {
+					continue
+				}
+				err := w.deliver(ctx, result.Change)
+				if err == nil {
+					continue
+				}
+				if w.DeadLetter != nil {
+					w.DeadLetter(result.Change, err)
+					continue
+				}
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+		})
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	// distinguish caller cancellation from a closed channel
+	return context.Cause(ctx)
+}
+
+// POSTs the change, retrying up to w.Retries times.
+func (w *Webhook) deliver(ctx context.Context, change couch.CouchDocumentChange) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("webhook: %s: encoding change: %w", change.ID, err)
+	}
+	delay := w.RetryDelay
+	if delay == 0 {
+		delay = time.Second
+	}
+	c := w.Clock
+	if c == nil {
+		c = clock.New()
+	}
+	for attempt := 0; ; attempt++ {
+		err = w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= w.Retries {
+			return fmt.Errorf("webhook: %s: %w", change.ID, err)
+		}
+		if err := clock.Sleep(ctx, c, delay); err != nil {
+			return fmt.Errorf("webhook: %s: %w", change.ID, err)
+		}
+	}
+}
+
+func (w *Webhook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("content-type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("doing request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %v from %s", res.StatusCode, res.Request.URL)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package sink
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kmsec-uk/npm-follower/clock"
+	"github.com/kmsec-uk/npm-follower/couch"
+)
+
+func TestWebhookConsume(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []string
+		failed   = true
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var c couch.CouchDocumentChange
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			t.Errorf("decoding body: %v", err)
+		}
+		// fail the first delivery of "b" to exercise retries
+		if c.ID == "b" && failed {
+			failed = false
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		received = append(received, c.ID)
+	}))
+	defer srv.Close()
+
+	results := make(chan couch.Result, 3)
+	results <- couch.Result{Change: couch.CouchDocumentChange{ID: "a"}}
+	results <- couch.Result{Change: couch.CouchDocumentChange{ID: "b"}}
+	results <- couch.Result{Change: couch.CouchDocumentChange{ID: "c"}}
+	close(results)
+
+	w := &Webhook{URL: srv.URL, Retries: 1, RetryDelay: 1}
+	if err := w.Consume(t.Context(), results); err != nil {
+		t.Fatalf("consume: %v", err)
+	}
+	if len(received) != 3 || received[0] != "a" || received[1] != "b" || received[2] != "c" {
+		t.Errorf("unexpected deliveries: %v", received)
+	}
+}
+
+func TestWebhookRetryClock(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	results := make(chan couch.Result, 1)
+	results <- couch.Result{Change: couch.CouchDocumentChange{ID: "a"}}
+	close(results)
+
+	fake := clock.NewFake(time.Now())
+	w := &Webhook{URL: srv.URL, Retries: 1, RetryDelay: time.Hour, Clock: fake}
+	done := make(chan error)
+	go func() { done <- w.Consume(t.Context(), results) }()
+
+	// the retry waits on the fake clock, not an hour of real time
+	fake.BlockUntil(1)
+	fake.Advance(time.Hour)
+	if err := <-done; err != nil {
+		t.Fatalf("consume: %v", err)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+}